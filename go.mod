@@ -3,6 +3,10 @@ module wget
 go 1.24.6
 
 require (
-	golang.org/x/net v0.44.0 // indirect
-	golang.org/x/time v0.13.0 // indirect
+	github.com/jlaffaye/ftp v0.2.4
+	golang.org/x/net v0.44.0
+	golang.org/x/term v0.35.0
+	golang.org/x/time v0.13.0
 )
+
+require golang.org/x/sys v0.36.0 // indirect
@@ -1,170 +1,458 @@
 package batch
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"wget/internal/downloader"
+	"wget/internal/httpclient"
 	"wget/internal/logging"
 )
 
 type Options struct {
-	OutputPath string
-	RateLimit  string
+	OutputPath         string
+	RateLimit          string
+	Quota              int64 // Total bytes to download, 0 for no limit
+	Continue           bool
+	Tries              int
+	ConnectTimeout     time.Duration
+	ReadTimeout        time.Duration
+	UserAgent          string
+	Headers            http.Header
+	User               string
+	Password           string
+	Netrc              *httpclient.Netrc // Logins used for hosts when User is empty, may be nil
+	Proxy              string
+	BindAddress        string
+	Network            string // "tcp4" or "tcp6" to connect only over IPv4 or IPv6, "" for either
+	NoCheckCertificate bool
+	Certificate        string // Client certificate file, see httpclient.Options
+	PrivateKey         string
+	CACertificate      string
+	CookieJar          http.CookieJar
+	MaxRedirects       int // Longest redirect chain followed, see httpclient.Options
+	NoClobber          bool
+	Backups            int // Earlier versions of a replaced file to keep, see downloader.Options
+	Timestamping       bool
+	PreserveTimestamp  bool
+	Concurrency        int  // Maximum simultaneous downloads, 0 uses DefaultConcurrency
+	FailFast           bool // Stop starting new downloads after the first failure
+	IgnoreLength       bool
+	TrustServerNames   bool
+	Spider             bool         // Only check that each URL exists
+	SaveHeaders        string       // See downloader.Options.SaveHeaders
+	Client             *http.Client // HTTP client for every download; when nil one is built from the connection options above
 }
 
+// DefaultConcurrency is the number of simultaneous downloads when none is configured
+const DefaultConcurrency = 5
+
 type DownloadResult struct {
-	URL   string
-	Error error
+	URL     string
+	Name    string // File name shown in the summary
+	Bytes   int64  // Bytes received by a successful download
+	Error   error
+	Skipped bool // Not attempted because of an interrupt or an earlier failure in fail-fast mode
+}
+
+// inputEntry is one line of an input file: a URL and an optional name to
+// save it under
+type inputEntry struct {
+	URL        string
+	OutputName string
 }
 
-// DownloadFromFile downloads multiple files from URLs listed in a file
-func DownloadFromFile(filename string, options *Options, logger *logging.Logger) error {
+// StdinFilename as the input file name reads the URL list from standard input
+const StdinFilename = "-"
+
+// DownloadFromFile downloads multiple files from URLs listed in a file, or
+// from standard input when filename is StdinFilename
+func DownloadFromFile(ctx context.Context, filename string, options *Options, logger *logging.Logger) error {
 	// Read URLs from file
-	urls, err := readURLsFromFile(filename)
+	entries, err := readURLsFromFile(filename)
 	if err != nil {
 		return fmt.Errorf("failed to read URLs from file: %v", err)
 	}
 
-	if len(urls) == 0 {
+	if len(entries) == 0 {
+		if filename == StdinFilename {
+			return fmt.Errorf("no URLs found on standard input")
+		}
 		return fmt.Errorf("no URLs found in file: %s", filename)
 	}
 
+	return downloadEntries(ctx, entries, options, logger)
+}
+
+// DownloadURLs downloads each of the given URLs, running up to
+// options.Concurrency downloads at once. Downloads that haven't started when
+// ctx is cancelled are skipped.
+func DownloadURLs(ctx context.Context, urls []string, options *Options, logger *logging.Logger) error {
+	entries := make([]inputEntry, len(urls))
+	for i, url := range urls {
+		entries[i] = inputEntry{URL: url}
+	}
+	return downloadEntries(ctx, entries, options, logger)
+}
+
+// downloadEntries implements DownloadURLs, saving each entry under its own
+// output name when one is given
+func downloadEntries(ctx context.Context, entries []inputEntry, options *Options, logger *logging.Logger) error {
 	// Calculate total content sizes (if possible)
-	contentSizes := make([]int64, len(urls))
+	contentSizes := make([]int64, len(entries))
 	totalSize := int64(0)
 
-	logger.Printf("Checking content sizes...\n")
-	for i, url := range urls {
-		size, err := getContentSize(url)
-		if err == nil && size > 0 {
-			contentSizes[i] = size
+	// Limit how many downloads and size checks run at once
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	// One client serves the size checks and every download, keeping enough
+	// idle connections for each worker to reuse its own
+	if !options.Spider {
+		logger.Printf("Checking content sizes...\n")
+	}
+	client := options.Client
+	if client == nil {
+		var err error
+		client, err = httpclient.New(&httpclient.Options{
+			ConnectTimeout:      options.ConnectTimeout,
+			ReadTimeout:         options.ReadTimeout,
+			Proxy:               options.Proxy,
+			BindAddress:         options.BindAddress,
+			Network:             options.Network,
+			NoCheckCertificate:  options.NoCheckCertificate,
+			Certificate:         options.Certificate,
+			PrivateKey:          options.PrivateKey,
+			CACertificate:       options.CACertificate,
+			Jar:                 options.CookieJar,
+			MaxRedirects:        options.MaxRedirects,
+			MaxIdleConnsPerHost: concurrency,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Probe sizes in parallel; each worker writes only its own slot. Spider
+	// mode transfers no bodies, so there is nothing to measure.
+	var sizeWG sync.WaitGroup
+	sizeSemaphore := make(chan struct{}, concurrency)
+	for i, entry := range entries {
+		if options.Spider {
+			break
+		}
+		sizeWG.Add(1)
+		go func(url string, index int) {
+			defer sizeWG.Done()
+
+			sizeSemaphore <- struct{}{}
+			defer func() { <-sizeSemaphore }()
+
+			size, err := getContentSize(ctx, client, url, options)
+			if err == nil && size > 0 {
+				contentSizes[index] = size
+			}
+		}(entry.URL, i)
+	}
+	sizeWG.Wait()
+	known := 0
+	for _, size := range contentSizes {
+		if size > 0 {
 			totalSize += size
+			known++
 		}
 	}
 
 	if totalSize > 0 {
-		logger.Printf("content size: %v\n", contentSizes)
+		logger.Printf("content size: %s [%d of %d files known]\n", logging.FormatBytes(totalSize), known, len(entries))
 	}
 
 	// Create channels for coordination
-	results := make(chan DownloadResult, len(urls))
+	results := make(chan DownloadResult, len(entries))
 	var wg sync.WaitGroup
 
+	// Show one progress line for the whole batch. Only files with a known
+	// size count toward the byte total; every file counts toward the tally.
+	var downloaded atomic.Int64
+	var completed atomic.Int64
+	done := make(chan struct{})
+	var progressWG sync.WaitGroup
+	progressWG.Add(1)
+	go func() {
+		defer progressWG.Done()
+		startTime := time.Now()
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				reportBatchProgress(downloaded.Load(), totalSize, int(completed.Load()), len(entries), startTime, logger)
+			case <-done:
+				reportBatchProgress(downloaded.Load(), totalSize, int(completed.Load()), len(entries), startTime, logger)
+				return
+			}
+		}
+	}()
+
+	// Every download draws on the same quota
+	quota := downloader.NewQuota(options.Quota)
+
+	semaphore := make(chan struct{}, concurrency)
+	var failed atomic.Bool
+	startTime := time.Now()
+
 	// Start downloads concurrently
-	for i, url := range urls {
+	for i, entry := range entries {
 		wg.Add(1)
-		go func(url string, index int) {
+		go func(entry inputEntry, index int) {
 			defer wg.Done()
 
-			// Create individual logger for this download (no progress bar in batch mode)
-			downloadLogger := logging.NewLogger(false)
+			url := entry.URL
+			name := entry.OutputName
+			if name == "" {
+				name = getFilenameFromURL(url)
+			}
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			// Don't start anything new once interrupted or, in fail-fast
+			// mode, once a download has failed
+			if ctx.Err() != nil || (options.FailFast && failed.Load()) {
+				completed.Add(1)
+				results <- DownloadResult{URL: url, Name: name, Skipped: true}
+				return
+			}
+
+			// Per-file output is replaced by the batch progress line
+			downloadLogger := logging.NewDiscardLogger()
 
 			// Create downloader options
 			downloaderOptions := &downloader.Options{
-				OutputPath: options.OutputPath,
-				RateLimit:  options.RateLimit,
+				OutputName:         entry.OutputName,
+				OutputPath:         options.OutputPath,
+				RateLimit:          options.RateLimit,
+				Quota:              quota,
+				Continue:           options.Continue,
+				Tries:              options.Tries,
+				ConnectTimeout:     options.ConnectTimeout,
+				ReadTimeout:        options.ReadTimeout,
+				UserAgent:          options.UserAgent,
+				Headers:            options.Headers,
+				User:               options.User,
+				Password:           options.Password,
+				Netrc:              options.Netrc,
+				Proxy:              options.Proxy,
+				BindAddress:        options.BindAddress,
+				Network:            options.Network,
+				NoCheckCertificate: options.NoCheckCertificate,
+				Certificate:        options.Certificate,
+				PrivateKey:         options.PrivateKey,
+				CACertificate:      options.CACertificate,
+				CookieJar:          options.CookieJar,
+				MaxRedirects:       options.MaxRedirects,
+				NoClobber:          options.NoClobber,
+				Backups:            options.Backups,
+				NumberDuplicates:   true,
+				Timestamping:       options.Timestamping,
+				PreserveTimestamp:  options.PreserveTimestamp,
+				IgnoreLength:       options.IgnoreLength,
+				TrustServerNames:   options.TrustServerNames,
+				Client:             client,
+				Spider:             options.Spider,
+				SaveHeaders:        options.SaveHeaders,
+			}
+			if contentSizes[index] > 0 {
+				downloaderOptions.Progress = &downloaded
 			}
 
 			// Download the file
-			err := downloader.DownloadFile(url, downloaderOptions, downloadLogger)
+			result, err := downloader.DownloadFileResult(ctx, url, downloaderOptions, downloadLogger)
+			var bytes int64
+			if err != nil {
+				failed.Store(true)
+			} else {
+				bytes = result.BytesWritten
+				// Report the name actually used, which may have been numbered
+				if result.OutputPath != "" {
+					name = filepath.Base(result.OutputPath)
+				}
+			}
+			completed.Add(1)
 
 			// Send result
 			results <- DownloadResult{
 				URL:   url,
+				Name:  name,
+				Bytes: bytes,
 				Error: err,
 			}
 
 			// Log completion
-			if err == nil {
-				filename := getFilenameFromURL(url)
-				logger.Printf("finished %s\n", filename)
+			if err == nil && options.Spider {
+				logger.Printf("exists %s\n", logging.RedactURL(url))
+			} else if err == nil {
+				logger.Printf("finished %s\n", name)
 			}
-		}(url, i)
+		}(entry, i)
 	}
 
 	// Wait for all downloads to complete
 	go func() {
 		wg.Wait()
+		close(done)
+		progressWG.Wait()
 		close(results)
 	}()
 
 	// Collect results
 	var successfulDownloads []string
-	var errors []error
+	var failures []error
+	skipped := 0
+	var totalBytes int64
 
 	for result := range results {
-		if result.Error != nil {
-			errors = append(errors, fmt.Errorf("failed to download %s: %v", result.URL, result.Error))
-		} else {
+		switch {
+		case result.Skipped:
+			skipped++
+		case result.Error != nil && options.Spider:
+			failures = append(failures, fmt.Errorf("%s: %w", logging.RedactURL(result.URL), result.Error))
+		case result.Error != nil:
+			failures = append(failures, fmt.Errorf("failed to download %s: %w", logging.RedactURL(result.URL), result.Error))
+		case options.Spider:
 			successfulDownloads = append(successfulDownloads, result.URL)
+		default:
+			successfulDownloads = append(successfulDownloads, result.Name)
+			totalBytes += result.Bytes
 		}
 	}
 
 	// Log final results
-	if len(successfulDownloads) > 0 {
+	if len(successfulDownloads) > 0 && options.Spider {
+		logger.Printf("\n%d of %d URLs exist\n", len(successfulDownloads), len(entries))
+	} else if len(successfulDownloads) > 0 {
 		logger.Printf("\nDownload finished: %v\n", successfulDownloads)
+		logger.LogBatchSummary(len(successfulDownloads), totalBytes, time.Since(startTime))
+	}
+	if skipped > 0 {
+		if ctx.Err() != nil {
+			logger.Printf("Interrupted, %d downloads skipped\n", skipped)
+		} else {
+			logger.Printf("Stopped after a failed download, %d skipped\n", skipped)
+		}
 	}
 
-	// Return first error if any occurred
-	if len(errors) > 0 {
-		return errors[0]
+	// Report every failure, not just the first
+	if len(failures) > 0 && options.Spider {
+		return fmt.Errorf("%d of %d URLs are broken:\n%w", len(failures), len(entries), errors.Join(failures...))
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d downloads failed:\n%w", len(failures), len(entries), errors.Join(failures...))
 	}
 
 	return nil
 }
 
-// readURLsFromFile reads URLs from a text file, one URL per line
-func readURLsFromFile(filename string) ([]string, error) {
+// reportBatchProgress logs aggregate progress, estimating the remaining time
+// from the average speed since the batch started
+func reportBatchProgress(downloaded, total int64, completed, files int, startTime time.Time, logger *logging.Logger) {
+	elapsed := time.Since(startTime).Seconds()
+	if elapsed == 0 {
+		return
+	}
+
+	speed := float64(downloaded) / elapsed
+	var eta time.Duration
+	if speed > 0 && total > downloaded {
+		eta = time.Duration(float64(total-downloaded)/speed) * time.Second
+	}
+	logger.LogBatchProgress(downloaded, total, completed, files, speed, eta)
+}
+
+// readURLsFromFile reads URLs from a text file, one URL per line. A URL may be
+// followed by whitespace and the name to save it under. The name
+// StdinFilename reads standard input instead.
+func readURLsFromFile(filename string) ([]inputEntry, error) {
 	// Read the entire file content first
-	content, err := os.ReadFile(filename)
+	var content []byte
+	var err error
+	if filename == StdinFilename {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(filename)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Convert to string and handle different encodings
 	text := string(content)
-	
+
 	// Remove BOM if present at the beginning of file
 	if strings.HasPrefix(text, "\uFEFF") {
 		text = strings.TrimPrefix(text, "\uFEFF")
 	}
-	
+
 	// Handle UTF-16 BOM by removing the problematic bytes
 	if len(content) >= 2 && content[0] == 0xFF && content[1] == 0xFE {
 		// UTF-16 LE BOM detected, try to clean it
 		text = strings.ReplaceAll(text, "\x00", "") // Remove null bytes from UTF-16
 		text = strings.TrimPrefix(text, "\xFF\xFE") // Remove BOM
 	}
-	
+
 	// Split into lines and process each
 	lines := strings.Split(text, "\n")
-	var urls []string
-	
+	var entries []inputEntry
+
 	for _, line := range lines {
 		// Clean the line thoroughly
 		line = strings.TrimSpace(line)
 		line = strings.ReplaceAll(line, "\r", "")
 		line = strings.ReplaceAll(line, "\x00", "") // Remove any remaining null characters
-		
+
 		// Remove any non-printable characters at the beginning
 		for len(line) > 0 && (line[0] < 32 || line[0] > 126) && line[0] != '\t' {
 			line = line[1:]
 		}
-		
+
 		if line != "" && !strings.HasPrefix(line, "#") {
-			urls = append(urls, line)
+			// Everything after the URL is the output name, which may
+			// contain spaces
+			entry := inputEntry{URL: line}
+			if i := strings.IndexAny(line, " \t"); i >= 0 {
+				entry.URL, entry.OutputName = line[:i], strings.TrimSpace(line[i:])
+			}
+			entries = append(entries, entry)
 		}
 	}
 
-	return urls, nil
+	return entries, nil
 }
 
-// getContentSize makes a HEAD request to get the content size without downloading
-func getContentSize(url string) (int64, error) {
-	resp, err := http.Head(url)
+// getContentSize makes a HEAD request to get the content size without
+// downloading. A URL without a scheme is tried over https:// and then
+// http://, as the download itself does.
+func getContentSize(ctx context.Context, client *http.Client, url string, options *Options) (int64, error) {
+	var resp *http.Response
+	var err error
+	if httpclient.MissingScheme(url) {
+		resp, err = sendHead(ctx, client, "https://"+url, options)
+		if err != nil && ctx.Err() == nil {
+			resp, err = sendHead(ctx, client, "http://"+url, options)
+		}
+	} else {
+		resp, err = sendHead(ctx, client, url, options)
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -177,6 +465,23 @@ func getContentSize(url string) (int64, error) {
 	return resp.ContentLength, nil
 }
 
+// sendHead sends a HEAD request for url with the configured headers and credentials
+func sendHead(ctx context.Context, client *http.Client, url string, options *Options) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpclient.SetUserAgent(req, options.UserAgent)
+	httpclient.SetHeaders(req, options.Headers)
+	httpclient.SetBasicAuth(req, options.User, options.Password, options.Netrc)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, logging.RedactError(err)
+	}
+	return resp, nil
+}
+
 // getFilenameFromURL extracts filename from URL for logging purposes
 func getFilenameFromURL(urlStr string) string {
 	parts := strings.Split(urlStr, "/")
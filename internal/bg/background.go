@@ -1,25 +1,92 @@
 package bg
 
 import (
+	"context"
+	"net/http"
+	"time"
 	"wget/internal/downloader"
+	"wget/internal/httpclient"
 	"wget/internal/logging"
 )
 
 type Options struct {
-	OutputName string
-	OutputPath string
-	RateLimit  string
+	OutputName         string
+	OutputPath         string
+	RateLimit          string
+	Quota              int64 // Total bytes to download, 0 for no limit
+	Continue           bool
+	Tries              int
+	ConnectTimeout     time.Duration
+	ReadTimeout        time.Duration
+	UserAgent          string
+	Headers            http.Header
+	User               string
+	Password           string
+	Netrc              *httpclient.Netrc // Logins used for hosts when User is empty, may be nil
+	Proxy              string
+	BindAddress        string
+	Network            string // "tcp4" or "tcp6" to connect only over IPv4 or IPv6, "" for either
+	NoCheckCertificate bool
+	Certificate        string // Client certificate file, see httpclient.Options
+	PrivateKey         string
+	CACertificate      string
+	CookieJar          http.CookieJar
+	MaxRedirects       int // Longest redirect chain followed, see httpclient.Options
+	NoClobber          bool
+	Backups            int // Earlier versions of a replaced file to keep, see downloader.Options
+	Timestamping       bool
+	PreserveTimestamp  bool
+	Checksum           string
+	Chunks             int
+	PostData           string
+	IgnoreLength       bool
+	TrustServerNames   bool
+	Spider             bool
+	ServerResponse     bool
+	SaveHeaders        string
 }
 
-// DownloadInBackground downloads a file in the background with output redirected to log file
-func DownloadInBackground(url string, options *Options, logger *logging.Logger) error {
+// DownloadInBackground performs the download inside the detached process started
+// by Detach, with output redirected to the log file
+func DownloadInBackground(ctx context.Context, url string, options *Options, logger *logging.Logger) error {
 	// Convert bg.Options to downloader.Options
 	downloaderOptions := &downloader.Options{
-		OutputName: options.OutputName,
-		OutputPath: options.OutputPath,
-		RateLimit:  options.RateLimit,
+		OutputName:         options.OutputName,
+		OutputPath:         options.OutputPath,
+		RateLimit:          options.RateLimit,
+		Quota:              downloader.NewQuota(options.Quota),
+		Continue:           options.Continue,
+		Tries:              options.Tries,
+		ConnectTimeout:     options.ConnectTimeout,
+		ReadTimeout:        options.ReadTimeout,
+		UserAgent:          options.UserAgent,
+		Headers:            options.Headers,
+		User:               options.User,
+		Password:           options.Password,
+		Netrc:              options.Netrc,
+		Proxy:              options.Proxy,
+		BindAddress:        options.BindAddress,
+		Network:            options.Network,
+		NoCheckCertificate: options.NoCheckCertificate,
+		Certificate:        options.Certificate,
+		PrivateKey:         options.PrivateKey,
+		CACertificate:      options.CACertificate,
+		CookieJar:          options.CookieJar,
+		MaxRedirects:       options.MaxRedirects,
+		NoClobber:          options.NoClobber,
+		Backups:            options.Backups,
+		Timestamping:       options.Timestamping,
+		PreserveTimestamp:  options.PreserveTimestamp,
+		Checksum:           options.Checksum,
+		Chunks:             options.Chunks,
+		PostData:           options.PostData,
+		IgnoreLength:       options.IgnoreLength,
+		TrustServerNames:   options.TrustServerNames,
+		Spider:             options.Spider,
+		ServerResponse:     options.ServerResponse,
+		SaveHeaders:        options.SaveHeaders,
 	}
 
 	// Perform the download
-	return downloader.DownloadFile(url, downloaderOptions, logger)
+	return downloader.DownloadFile(ctx, url, downloaderOptions, logger)
 }
@@ -0,0 +1,54 @@
+package bg
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// DaemonFlag is passed to the re-executed child so it knows it is already detached
+const DaemonFlag = "--daemon"
+
+// PasswordEnv carries a prompted password to the child without exposing it in its arguments
+const PasswordEnv = "WGET_DAEMON_PASSWORD"
+
+// Detach re-executes the current program in the background with DaemonFlag
+// added, redirecting its output to logFile. It returns the child's PID without
+// waiting for it to finish.
+func Detach(logFile string, password string) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to locate executable: %v", err)
+	}
+
+	output, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer output.Close()
+
+	// Flags must precede the URL, so the daemon flag goes first
+	args := append([]string{DaemonFlag}, os.Args[1:]...)
+
+	cmd := exec.Command(executable, args...)
+	cmd.Stdin = nil
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Env = os.Environ()
+	if password != "" {
+		cmd.Env = append(cmd.Env, PasswordEnv+"="+password)
+	}
+	cmd.SysProcAttr = detachedProcAttr()
+
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start background process: %v", err)
+	}
+
+	pid := cmd.Process.Pid
+	// The child runs independently; don't keep a handle to it
+	if err := cmd.Process.Release(); err != nil {
+		return pid, err
+	}
+
+	return pid, nil
+}
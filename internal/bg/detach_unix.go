@@ -0,0 +1,11 @@
+//go:build !windows
+
+package bg
+
+import "syscall"
+
+// detachedProcAttr starts the child in its own session so it survives the
+// parent's terminal closing
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
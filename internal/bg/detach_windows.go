@@ -0,0 +1,15 @@
+//go:build windows
+
+package bg
+
+import "syscall"
+
+const (
+	detachedProcess       = 0x00000008
+	createNewProcessGroup = 0x00000200
+)
+
+// detachedProcAttr starts the child without a console in its own process group
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess | createNewProcessGroup}
+}
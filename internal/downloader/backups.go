@@ -0,0 +1,31 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+)
+
+// rotateBackups moves an existing file at path to path.1 before it is
+// replaced, shifting older backups up by one and dropping the one past keep
+func rotateBackups(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	backup := func(n int) string { return fmt.Sprintf("%s.%d", path, n) }
+	if err := os.Remove(backup(keep)); err != nil && !os.IsNotExist(err) {
+		return &WriteError{Op: "remove oldest backup", Err: err}
+	}
+	for n := keep - 1; n >= 1; n-- {
+		if err := os.Rename(backup(n), backup(n+1)); err != nil && !os.IsNotExist(err) {
+			return &WriteError{Op: "rotate backups", Err: err}
+		}
+	}
+	if err := os.Rename(path, backup(1)); err != nil {
+		return &WriteError{Op: fmt.Sprintf("back up %s", path), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// Checksum verifies downloaded data against an expected digest
+type Checksum struct {
+	Algorithm string
+	Expected  string
+	hash      hash.Hash
+}
+
+// ParseChecksum parses a checksum specification of the form "algorithm:hexdigest".
+// Supported algorithms are sha256, sha1 and md5.
+func ParseChecksum(spec string) (*Checksum, error) {
+	algorithm, expected, found := strings.Cut(strings.TrimSpace(spec), ":")
+	if !found || expected == "" {
+		return nil, fmt.Errorf("invalid checksum %q: expected ALGORITHM:DIGEST", spec)
+	}
+	algorithm = strings.ToLower(algorithm)
+	expected = strings.ToLower(expected)
+
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha1":
+		h = sha1.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+
+	if _, err := hex.DecodeString(expected); err != nil || len(expected) != h.Size()*2 {
+		return nil, fmt.Errorf("invalid %s digest: %s", algorithm, expected)
+	}
+
+	return &Checksum{
+		Algorithm: algorithm,
+		Expected:  expected,
+		hash:      h,
+	}, nil
+}
+
+// Write feeds downloaded bytes into the running digest
+func (c *Checksum) Write(p []byte) (int, error) {
+	return c.hash.Write(p)
+}
+
+// addFile feeds the contents of an existing file into the digest, used when
+// resuming a download whose first part is already on disk
+func (c *Checksum) addFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(c.hash, file)
+	return err
+}
+
+// Sum returns the hex-encoded digest of everything written so far
+func (c *Checksum) Sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// Verify compares the computed digest with the expected one
+func (c *Checksum) Verify() error {
+	if computed := c.Sum(); computed != c.Expected {
+		return fmt.Errorf("checksum mismatch: expected %s:%s, computed %s:%s",
+			c.Algorithm, c.Expected, c.Algorithm, computed)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wget/internal/httpclient"
+	"wget/internal/logging"
+	"wget/internal/ratelimit"
+
+	"golang.org/x/time/rate"
+)
+
+// probeRangeSupport issues a HEAD request and returns the content length and
+// the (closed) response when the server advertises byte range support. The caller treats errors as a cue
+// to fall back to a single stream, so the probe is not retried.
+func probeRangeSupport(ctx context.Context, client *http.Client, urlStr string, options *Options, logger *logging.Logger) (int64, *http.Response, error) {
+	req, err := newRequest(ctx, http.MethodHead, urlStr, options)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, logging.RedactError(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, nil, fmt.Errorf("server returned status: %s", resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") || resp.ContentLength <= 0 {
+		return 0, nil, fmt.Errorf("server does not support byte ranges")
+	}
+
+	logger.LogStatus(resp.Status)
+	if options.ServerResponse {
+		logger.LogServerResponse(resp)
+	}
+	return resp.ContentLength, resp, nil
+}
+
+// downloadChunked fetches size bytes as options.Chunks concurrent range
+// requests into temporary files, then joins them in order into partPath
+func downloadChunked(ctx context.Context, client *http.Client, urlStr, outputPath, partPath string,
+	size int64, checksum *Checksum, modTime time.Time, options *Options, result *Result, logger *logging.Logger) error {
+	chunks := int64(options.Chunks)
+	if chunks > size {
+		chunks = size
+	}
+
+	logger.LogContentSize(size)
+	logger.Printf("downloading in %d parts\n", chunks)
+	logger.LogSavingTo(outputPath)
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return &WriteError{Op: "create directory", Err: err}
+	}
+
+	var limiter *rate.Limiter
+	if options.RateLimit != "" {
+		var err error
+		limiter, err = ratelimit.Parse(options.RateLimit)
+		if err != nil {
+			return fmt.Errorf("invalid rate limit: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Report aggregate progress across all chunks
+	var downloaded atomic.Int64
+	speed := newSpeedWindow(time.Now(), 0)
+	done := make(chan struct{})
+	var progressWG sync.WaitGroup
+	progressWG.Add(1)
+	go func() {
+		defer progressWG.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				reportChunkProgress(downloaded.Load(), size, speed, options.ProgressFunc, logger)
+			case <-done:
+				reportChunkProgress(downloaded.Load(), size, speed, options.ProgressFunc, logger)
+				return
+			}
+		}
+	}()
+
+	// Download every chunk into its own temporary file
+	chunkSize := size / chunks
+	chunkPaths := make([]string, chunks)
+	errs := make([]error, chunks)
+	var wg sync.WaitGroup
+	for i := int64(0); i < chunks; i++ {
+		start := i * chunkSize
+		end := start + chunkSize - 1
+		if i == chunks-1 {
+			end = size - 1
+		}
+		chunkPaths[i] = fmt.Sprintf("%s.%d", partPath, i)
+
+		wg.Add(1)
+		go func(index int64, start, end int64) {
+			defer wg.Done()
+			err := downloadChunk(ctx, cancel, client, urlStr, chunkPaths[index], start, end, &downloaded, limiter, options, logger)
+			if err != nil {
+				errs[index] = err
+				cancel()
+			}
+		}(i, start, end)
+	}
+	wg.Wait()
+	close(done)
+	progressWG.Wait()
+	// Bytes of failed chunks were received too and count toward the quota
+	result.BytesWritten = downloaded.Load()
+
+	if size > 0 && options.ProgressFunc == nil {
+		logger.EndProgress()
+	}
+
+	defer func() {
+		for _, chunkPath := range chunkPaths {
+			os.Remove(chunkPath)
+		}
+	}()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to download part %d: %w", i+1, err)
+		}
+	}
+
+	// Join the chunks in order, hashing as we go
+	if err := joinChunks(partPath, chunkPaths, checksum); err != nil {
+		return err
+	}
+
+	return completeDownload(urlStr, partPath, outputPath, checksum, modTime, options.Backups, result, logger)
+}
+
+// downloadChunk fetches bytes start..end (inclusive) into chunkPath. cancel
+// stops every chunk, so one stalled part aborts the whole download.
+func downloadChunk(ctx context.Context, cancel context.CancelFunc, client *http.Client, urlStr, chunkPath string, start, end int64,
+	downloaded *atomic.Int64, limiter *rate.Limiter, options *Options, logger *logging.Logger) error {
+	req, err := newRequest(ctx, http.MethodGet, urlStr, options)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := doWithRetry(client, req, options.Tries, logger)
+	if err != nil {
+		return &NetworkError{Op: "make request", Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	file, err := os.Create(chunkPath)
+	if err != nil {
+		return &WriteError{Op: "create file", Err: err}
+	}
+	defer file.Close()
+
+	// Abort the transfer if the server stops sending data
+	body := httpclient.NewIdleReader(resp.Body, options.ReadTimeout, cancel)
+	defer body.Stop()
+
+	written, err := copyToFile(file, &chunkReader{
+		reader:     ratelimit.NewReader(ctx, body, limiter),
+		downloaded: downloaded,
+	})
+	if err != nil {
+		return err
+	}
+	if expected := end - start + 1; written != expected {
+		return &NetworkError{Op: "read response", Err: fmt.Errorf("received %d bytes, expected %d", written, expected)}
+	}
+
+	if err := file.Close(); err != nil {
+		return &WriteError{Op: "close file", Err: err}
+	}
+	return nil
+}
+
+// chunkReader counts bytes into a shared counter
+type chunkReader struct {
+	reader     io.Reader
+	downloaded *atomic.Int64
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	if n > 0 {
+		cr.downloaded.Add(int64(n))
+	}
+	return n, err
+}
+
+// joinChunks concatenates the chunk files into partPath
+func joinChunks(partPath string, chunkPaths []string, checksum *Checksum) error {
+	out, err := os.Create(partPath)
+	if err != nil {
+		return &WriteError{Op: "create file", Err: err}
+	}
+	defer out.Close()
+
+	var writer io.Writer = out
+	if checksum != nil {
+		writer = io.MultiWriter(out, checksum)
+	}
+
+	for _, chunkPath := range chunkPaths {
+		chunk, err := os.Open(chunkPath)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", chunkPath, err)
+		}
+		_, err = io.Copy(writer, chunk)
+		chunk.Close()
+		if err != nil {
+			return &WriteError{Op: "join " + chunkPath, Err: err}
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return &WriteError{Op: "close file", Err: err}
+	}
+	return nil
+}
+
+// reportChunkProgress renders the aggregate progress of a chunked download,
+// or hands it to callback when one is set
+func reportChunkProgress(downloaded, total int64, window *speedWindow, callback ProgressFunc, logger *logging.Logger) {
+	speed := window.add(time.Now(), downloaded)
+	var eta time.Duration
+	if speed > 0 {
+		eta = time.Duration(float64(total-downloaded)/speed) * time.Second
+	}
+
+	if callback != nil {
+		callback(downloaded, total, speed, eta)
+		return
+	}
+	logger.LogProgress(downloaded, total, speed, eta)
+}
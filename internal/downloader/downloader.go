@@ -2,39 +2,154 @@ package downloader
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"wget/internal/httpclient"
 	"wget/internal/logging"
-
-	"golang.org/x/time/rate"
 )
 
 type Options struct {
-	OutputName string
-	OutputPath string
-	RateLimit  string
+	OutputName         string
+	OutputPath         string
+	RateLimit          string
+	Continue           bool
+	Tries              int // Number of attempts, 0 means retry forever
+	ConnectTimeout     time.Duration
+	ReadTimeout        time.Duration
+	UserAgent          string
+	Headers            http.Header
+	User               string
+	Password           string
+	Netrc              *httpclient.Netrc // Logins used for hosts when User is empty, may be nil
+	Proxy              string
+	BindAddress        string
+	Network            string // "tcp4" or "tcp6" to connect only over IPv4 or IPv6, "" for either
+	NoCheckCertificate bool
+	Certificate        string // Client certificate file, see httpclient.Options
+	PrivateKey         string
+	CACertificate      string
+	CookieJar          http.CookieJar
+	MaxRedirects       int           // Longest redirect chain followed, see httpclient.Options
+	Checksum           string        // Expected digest as "algorithm:hex", e.g. "sha256:ab12..."
+	Chunks             int           // Number of parallel range requests, 0 or 1 for a single stream
+	NoClobber          bool          // Skip the download when the target file already exists
+	NumberDuplicates   bool          // Save as NAME.1, NAME.2, ... instead of replacing an existing file
+	Backups            int           // Earlier versions of a replaced file to keep as NAME.1 to NAME.N, 0 for none
+	Timestamping       bool          // Only download when the server copy is newer than the local file
+	PreserveTimestamp  bool          // Give the saved file the server's Last-Modified time
+	PostData           string        // Form-encoded request body, sends a POST instead of a GET when set
+	Progress           *atomic.Int64 // When set, downloaded bytes are also added to this shared counter
+	IgnoreLength       bool          // Don't trust the Content-Length header of the response
+	TrustServerNames   bool          // Name the file after the final URL when redirected
+	Client             *http.Client  // Shared HTTP client; when nil one is built from the connection options above
+	Spider             bool          // Only check that the URL exists, writing nothing to disk
+	ServerResponse     bool          // Log the status line and headers of each response
+	SaveHeaders        string        // SaveHeadersInline or SaveHeadersSidecar to keep the response headers, "" to drop them
+	Quota              *Quota        // Shared download quota, nil for no limit
+	MaxInMemory        int64         // Largest response DownloadBytes keeps in memory, 0 uses DefaultMaxInMemory; library only, negative is an error
+	ProgressFunc       ProgressFunc  // Receives progress updates instead of the built-in progress display when set
+}
+
+// ProgressFunc receives the progress of a download a few times a second and
+// once at the end. downloaded includes any data resumed from disk. total is -1
+// when the size is unknown, in which case eta is 0. speed is in bytes per
+// second over the last few seconds.
+type ProgressFunc func(downloaded, total int64, speed float64, eta time.Duration)
+
+// Result describes a completed download
+type Result struct {
+	OutputPath   string        // Final location of the file, empty in spider mode
+	BytesWritten int64         // Bytes received in this run, not counting data resumed from disk
+	StatusCode   int           // Status of the HTTP response, 0 for FTP
+	Elapsed      time.Duration // Time taken by the whole download, including retries
+	Checksum     string        // Hex digest of the file when options.Checksum was set
+	Skipped      bool          // The existing file was kept by no-clobber or timestamping
+
+	quotaReserved int64 // Bytes claimed from options.Quota, see Quota.reserve
 }
 
 type ProgressReader struct {
-	reader     io.Reader
+	reader     io.Reader // Rate limited by the caller when needed
 	total      int64
 	downloaded int64
+	offset     int64
 	lastUpdate time.Time
 	startTime  time.Time
 	logger     *logging.Logger
-	limiter    *rate.Limiter
+	checksum   *Checksum
+	counter    *atomic.Int64 // Optional shared byte counter, see Options.Progress
+	frame      int           // Spinner frame shown when total is unknown
+	speed      *speedWindow  // Recent speed, for the progress line and ETA
+	callback   ProgressFunc  // Replaces the logger's progress display, see Options.ProgressFunc
 }
 
-// DownloadFile downloads a single file from the given URL
-func DownloadFile(urlStr string, options *Options, logger *logging.Logger) error {
+// DownloadFile downloads a single file from the given URL. Cancelling ctx
+// aborts the transfer and removes the partial file unless options.Continue is
+// set, in which case it is kept for resuming.
+func DownloadFile(ctx context.Context, urlStr string, options *Options, logger *logging.Logger) error {
+	_, err := DownloadFileResult(ctx, urlStr, options, logger)
+	return err
+}
+
+// DownloadFileResult downloads a single file like DownloadFile and reports
+// where it was saved and how the transfer went. A nil logger discards the
+// progress output. Failures are reported as a *StatusError, *NetworkError or
+// *WriteError where the cause is known, for use with errors.As.
+func DownloadFileResult(ctx context.Context, urlStr string, options *Options, logger *logging.Logger) (*Result, error) {
+	if logger == nil {
+		logger = logging.NewDiscardLogger()
+	}
+
+	result := &Result{}
+	startTime := time.Now()
+	var err error
+	if httpclient.MissingScheme(urlStr) {
+		err = downloadWithAssumedScheme(ctx, urlStr, options, result, logger)
+	} else {
+		err = downloadFile(ctx, urlStr, options, result, logger)
+	}
+	result.Elapsed = time.Since(startTime)
+	options.Quota.settle(result)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Skipped && !options.Spider {
+		logger.LogSummary(result.BytesWritten, result.Elapsed)
+	}
+	return result, nil
+}
+
+// downloadWithAssumedScheme downloads a URL given without a scheme over
+// https://, retrying over http:// when no response could be received. The
+// https:// attempt is made only once so an unreachable port fails fast.
+func downloadWithAssumedScheme(ctx context.Context, urlStr string, options *Options, result *Result, logger *logging.Logger) error {
+	logger.Printf("No scheme given, assuming %s\n", logging.RedactURL("https://"+urlStr))
+	httpsOptions := *options
+	httpsOptions.Tries = 1
+	err := downloadFile(ctx, "https://"+urlStr, &httpsOptions, result, logger)
+
+	var networkErr *NetworkError
+	if !errors.As(err, &networkErr) || result.StatusCode != 0 || ctx.Err() != nil {
+		return err
+	}
+	logger.Printf("%s failed: %v, falling back to http://\n", logging.RedactURL("https://"+urlStr), networkErr.Err)
+	options.Quota.settle(result)
+	*result = Result{}
+	return downloadFile(ctx, "http://"+urlStr, options, result, logger)
+}
+
+// downloadFile performs the download for DownloadFileResult, filling in result
+func downloadFile(ctx context.Context, urlStr string, options *Options, result *Result, logger *logging.Logger) error {
 	logger.LogStart()
 
 	// Parse and validate URL
@@ -42,82 +157,339 @@ func DownloadFile(urlStr string, options *Options, logger *logging.Logger) error
 	if err != nil {
 		return fmt.Errorf("invalid URL: %v", err)
 	}
+	if err := CheckScheme(parsedURL); err != nil {
+		return err
+	}
 
-	// Create HTTP client
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	// Spider mode never touches the output file
+	if options.Spider {
+		switch parsedURL.Scheme {
+		case "ftp":
+			return fmt.Errorf("--spider is not supported for FTP URLs")
+		case "file":
+			return fmt.Errorf("--spider is not supported for file URLs")
+		}
+		client, err := newClient(options)
+		if err != nil {
+			return err
+		}
+		return checkRemoteFile(ctx, client, urlStr, options, result, logger)
 	}
 
-	// Make HTTP request
-	resp, err := client.Get(urlStr)
+	// Determine output file path
+	outputPath, err := determineOutputPath(urlStr, parsedURL, options)
 	if err != nil {
-		return fmt.Errorf("failed to make request: %v", err)
+		return fmt.Errorf("failed to determine output path: %v", err)
 	}
-	defer resp.Body.Close()
 
-	// Log response status
-	logger.LogStatus(resp.Status)
+	// Keep an existing file by saving under a numbered name
+	var names *nameClaim
+	if numberDuplicates(options) {
+		names = &nameClaim{}
+		defer names.release()
+		outputPath = names.claim(outputPath)
+	}
 
-	// Check if response is successful
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status: %s", resp.Status)
+	// Keep an existing file untouched in no-clobber mode
+	if options.NoClobber {
+		if _, err := os.Stat(outputPath); err == nil {
+			logger.LogNoClobber(outputPath)
+			result.OutputPath, result.Skipped = outputPath, true
+			return nil
+		}
 	}
 
-	// Get content length
-	contentLength := resp.ContentLength
-	if contentLength > 0 {
-		logger.LogContentSize(contentLength)
+	// Look for a previous partial download to resume
+	partPath, offset := findPartialDownload(outputPath, options.Continue)
+
+	// Inline headers must start the file, so a partial download can't be
+	// continued
+	if options.SaveHeaders == SaveHeadersInline {
+		partPath, offset = outputPath+".part", 0
 	}
 
-	// Determine output file path
-	outputPath, err := determineOutputPath(urlStr, parsedURL, options)
+	// Set up checksum verification if requested
+	var checksum *Checksum
+	if options.Checksum != "" {
+		checksum, err = ParseChecksum(options.Checksum)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The idle timer cancels only this download's context, which keeps a
+	// stalled transfer distinguishable from an interrupt of the caller's ctx
+	interrupt := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	switch parsedURL.Scheme {
+	case "ftp":
+		return downloadFTP(ctx, interrupt, cancel, parsedURL, outputPath, partPath, offset, checksum, options, result, logger)
+	case "file":
+		return downloadLocalFile(ctx, interrupt, parsedURL, outputPath, partPath, offset, checksum, options, result, logger)
+	}
+
+	client, err := newClient(options)
 	if err != nil {
-		return fmt.Errorf("failed to determine output path: %v", err)
+		return err
 	}
 
-	logger.LogSavingTo(outputPath)
+	// Split fresh downloads into parallel ranges when the server allows it
+	// (timestamp checks need a conditional GET, a POST body can't be replayed
+	// per range, ranges can't be planned from an untrusted length and saved
+	// headers must come from one full response, so those use a single stream)
+	localModTime, hasLocalCopy := localFileModTime(outputPath, options.Timestamping)
+	if options.Chunks > 1 && offset == 0 && !hasLocalCopy && options.PostData == "" && !options.IgnoreLength && options.SaveHeaders == "" {
+		size, probe, err := probeRangeSupport(ctx, client, urlStr, options, logger)
+		if err == nil && size > 0 {
+			modTime := serverModTime(probe.Header, options)
+			if name := serverFilename(probe, options); name != "" {
+				outputPath = filepath.Join(filepath.Dir(outputPath), name)
+				if names != nil {
+					outputPath = names.claim(outputPath)
+				}
+				partPath = outputPath + ".part"
+			}
+			result.StatusCode = probe.StatusCode
+			if err := options.Quota.reserve(size, result); err != nil {
+				return err
+			}
+			return downloadChunked(ctx, client, urlStr, outputPath, partPath, size, checksum, modTime, options, result, logger)
+		}
+		logger.Printf("server does not support parallel ranges, using a single connection\n")
+	}
 
-	// Create output directory if needed
-	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
+	// Ask only for the missing bytes when resuming
+	header := make(http.Header)
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// A changed resource is sent whole instead of appended to old bytes
+		if validator := loadValidator(partPath); validator != "" {
+			header.Set("If-Range", validator)
+		}
+	}
+	if hasLocalCopy {
+		header.Set("If-Modified-Since", localModTime.UTC().Format(http.TimeFormat))
+	}
+
+	freshDownload := offset == 0
+
+	resp, err := sendRequest(ctx, client, urlStr, header, options, result, logger)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
+
+	// Decide whether to append to the partial file or start over
+	switch {
+	case resp.StatusCode == http.StatusNotModified && hasLocalCopy:
+		logger.LogNotModified(outputPath)
+		result.OutputPath, result.Skipped = outputPath, true
+		return nil
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		logger.LogResuming(offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file already holds the whole resource
+		return completeExistingPart(urlStr, partPath, outputPath, checksum, time.Time{}, options, result, logger)
+	case isFullResponse(resp.StatusCode):
+		// Server ignored the range request, restart from scratch
+		offset = 0
+	default:
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	// Prefer the file name suggested by the server for fresh downloads
+	if freshDownload {
+		if name := serverFilename(resp, options); name != "" {
+			outputPath = filepath.Join(filepath.Dir(outputPath), name)
+			if names != nil {
+				outputPath = names.claim(outputPath)
+			}
+			partPath = outputPath + ".part"
+			if options.NoClobber {
+				if _, err := os.Stat(outputPath); err == nil {
+					logger.LogNoClobber(outputPath)
+					result.OutputPath, result.Skipped = outputPath, true
+					return nil
+				}
+			}
+		}
+	}
+
+	// Get content length, unless the server is known to report a bogus one
+	contentLength := resp.ContentLength
+	if options.IgnoreLength {
+		contentLength = -1
+	}
+	if err := options.Quota.reserve(contentLength, result); err != nil {
+		return err
+	}
+	if contentLength > 0 {
+		contentLength += offset
+	}
+	logger.LogContentSize(contentLength)
+
+	logger.LogSavingTo(outputPath)
 
-	// Create output file
-	file, err := os.Create(outputPath)
+	file, err := openPartFile(outputPath, partPath, offset, checksum)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
+		return err
 	}
 	defer file.Close()
+	if offset == 0 {
+		saveValidator(partPath, outputPath, resp.Header)
+	}
 
-	// Set up rate limiter if specified
-	var limiter *rate.Limiter
-	if options.RateLimit != "" {
-		limiter, err = parseRateLimit(options.RateLimit)
-		if err != nil {
-			return fmt.Errorf("invalid rate limit: %v", err)
+	if options.SaveHeaders == SaveHeadersInline {
+		if _, err := file.Write(formatResponseHeaders(resp)); err != nil {
+			return &WriteError{Op: "write headers", Err: err}
 		}
 	}
 
-	// Create progress reader
-	progressReader := &ProgressReader{
-		reader:     resp.Body,
-		total:      contentLength,
-		downloaded: 0,
-		lastUpdate: time.Now(),
-		startTime:  time.Now(),
-		logger:     logger,
-		limiter:    limiter,
+	// Abort the transfer if the server stops sending data
+	body := httpclient.NewIdleReader(resp.Body, options.ReadTimeout, cancel)
+	defer body.Stop()
+
+	written, err := copyToPartFile(ctx, interrupt, file, body, partPath, offset, contentLength, resp.ContentLength, checksum, options, logger)
+	// Bytes received before a failure still count toward the quota
+	result.BytesWritten = written
+	if err != nil {
+		return err
+	}
+
+	if err := completeDownload(urlStr, partPath, outputPath, checksum, serverModTime(resp.Header, options), options.Backups, result, logger); err != nil {
+		return err
+	}
+
+	// Only a download that passed verification gets its headers saved
+	if options.SaveHeaders == SaveHeadersSidecar {
+		return writeHeadersFile(outputPath, resp)
+	}
+	return nil
+}
+
+// CheckScheme rejects URLs whose scheme the downloader can't fetch
+func CheckScheme(parsedURL *url.URL) error {
+	switch parsedURL.Scheme {
+	case "http", "https", "ftp", "file":
+		return nil
+	}
+	return fmt.Errorf("unsupported scheme %q in %s (supported: http, https, ftp, file)", parsedURL.Scheme, logging.RedactURL(parsedURL.String()))
+}
+
+// newClient returns options.Client, so connections are reused across
+// downloads, or builds a client from the connection options
+func newClient(options *Options) (*http.Client, error) {
+	if options.Client != nil {
+		return options.Client, nil
+	}
+	return httpclient.New(&httpclient.Options{
+		ConnectTimeout:     options.ConnectTimeout,
+		ReadTimeout:        options.ReadTimeout,
+		Proxy:              options.Proxy,
+		BindAddress:        options.BindAddress,
+		Network:            options.Network,
+		NoCheckCertificate: options.NoCheckCertificate,
+		Certificate:        options.Certificate,
+		PrivateKey:         options.PrivateKey,
+		CACertificate:      options.CACertificate,
+		Jar:                options.CookieJar,
+		MaxRedirects:       options.MaxRedirects,
+	})
+}
+
+// removeInterruptedPart deletes the partial file of a download whose caller
+// cancelled it, unless options.Continue asks to keep it for resuming
+func removeInterruptedPart(interrupt context.Context, partPath string, options *Options) {
+	if interrupt.Err() != nil && !options.Continue {
+		os.Remove(partPath)
+		removeValidator(partPath)
+	}
+}
+
+// newRequest creates a request carrying the configured user agent, headers and
+// credentials. POST requests carry options.PostData as a form-encoded body.
+func newRequest(ctx context.Context, method, urlStr string, options *Options) (*http.Request, error) {
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(options.PostData)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	httpclient.SetUserAgent(req, options.UserAgent)
+	httpclient.SetHeaders(req, options.Headers)
+	httpclient.SetBasicAuth(req, options.User, options.Password, options.Netrc)
+	return req, nil
+}
+
+// sendRequest sends a GET for urlStr, or a POST of options.PostData, with the
+// fields of header added and retries as configured. The response status is
+// logged and recorded in result.
+func sendRequest(ctx context.Context, client *http.Client, urlStr string, header http.Header,
+	options *Options, result *Result, logger *logging.Logger) (*http.Response, error) {
+	method := http.MethodGet
+	if options.PostData != "" {
+		method = http.MethodPost
+	}
+	req, err := newRequest(ctx, method, urlStr, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
 	}
 
-	// Copy data with progress tracking
-	_, err = io.Copy(file, progressReader)
+	resp, err := doWithRetry(client, req, options.Tries, logger)
 	if err != nil {
-		return fmt.Errorf("failed to download file: %v", err)
+		return nil, &NetworkError{Op: "make request", Err: err}
 	}
 
-	// Final newline after progress bar
-	if contentLength > 0 {
-		fmt.Println()
+	logger.LogStatus(resp.Status)
+	if options.ServerResponse {
+		logger.LogServerResponse(resp)
+	}
+	result.StatusCode = resp.StatusCode
+	return resp, nil
+}
+
+// isFullResponse reports whether a response status carries the whole
+// resource in its body: any success other than 206 Partial Content
+func isFullResponse(status int) bool {
+	return status/100 == 2 && status != http.StatusPartialContent
+}
+
+// completeDownload verifies the checksum of a finished partial file, moves it
+// into place and logs the result. The file is deleted on checksum mismatch.
+// A file it replaces is kept as one of up to backups numbered copies. A
+// non-zero modTime is applied to the final file. The final path and digest
+// are recorded in result.
+func completeDownload(urlStr, partPath, outputPath string, checksum *Checksum, modTime time.Time, backups int, result *Result, logger *logging.Logger) error {
+	if checksum != nil {
+		if err := checksum.Verify(); err != nil {
+			os.Remove(partPath)
+			removeValidator(partPath)
+			return err
+		}
+		logger.Printf("checksum OK: %s:%s\n", checksum.Algorithm, checksum.Sum())
+	}
+	if err := finishPartialDownload(partPath, outputPath, backups); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(outputPath, modTime, modTime); err != nil {
+			logger.Printf("Warning: failed to set modification time of %s: %v\n", outputPath, err)
+		}
+	}
+
+	result.OutputPath = outputPath
+	if checksum != nil {
+		result.Checksum = checksum.Sum()
 	}
 
 	logger.LogDownloaded(urlStr)
@@ -126,23 +498,19 @@ func DownloadFile(urlStr string, options *Options, logger *logging.Logger) error
 	return nil
 }
 
-// Read implements io.Reader interface with progress tracking and rate limiting
+// Read implements io.Reader interface with progress tracking
 func (pr *ProgressReader) Read(p []byte) (int, error) {
 	n, err := pr.reader.Read(p)
-	
-	// Apply rate limiting if configured and we actually read data
-	if n > 0 && pr.limiter != nil {
-		// Wait for rate limiter permission for the bytes we actually read
-		// Use context.Background() instead of nil
-		waitErr := pr.limiter.WaitN(context.Background(), n)
-		if waitErr != nil {
-			return n, waitErr
-		}
-	}
 
 	if n > 0 {
 		pr.downloaded += int64(n)
-		
+		if pr.counter != nil {
+			pr.counter.Add(int64(n))
+		}
+		if pr.checksum != nil {
+			pr.checksum.Write(p[:n])
+		}
+
 		// Update progress every 100ms to avoid too frequent updates
 		now := time.Now()
 		if now.Sub(pr.lastUpdate) >= 100*time.Millisecond || err == io.EOF {
@@ -154,18 +522,25 @@ func (pr *ProgressReader) Read(p []byte) (int, error) {
 }
 
 func (pr *ProgressReader) updateProgress() {
-	if pr.total <= 0 {
-		return // Can't show progress without content length
+	if pr.total == 0 {
+		return // Nothing to show for an empty body
 	}
 
-	elapsed := time.Since(pr.startTime)
-	if elapsed.Seconds() == 0 {
+	// Speed and ETA follow the last few seconds rather than the whole
+	// transfer, so they keep up when the connection speeds up or slows down
+	speed := pr.speed.add(time.Now(), pr.downloaded)
+
+	// Without a content length, show a spinner and the running total
+	if pr.total < 0 {
+		if pr.callback != nil {
+			pr.callback(pr.downloaded, -1, speed, 0)
+			return
+		}
+		pr.logger.LogUnknownProgress(pr.downloaded, pr.frame, speed)
+		pr.frame++
 		return
 	}
 
-	// Calculate speed (bytes per second)
-	speed := float64(pr.downloaded) / elapsed.Seconds()
-
 	// Calculate ETA
 	var eta time.Duration
 	if speed > 0 {
@@ -173,9 +548,134 @@ func (pr *ProgressReader) updateProgress() {
 		eta = time.Duration(float64(remaining)/speed) * time.Second
 	}
 
+	if pr.callback != nil {
+		pr.callback(pr.downloaded, pr.total, speed, eta)
+		return
+	}
 	pr.logger.LogProgress(pr.downloaded, pr.total, speed, eta)
 }
 
+// finish ends the progress display once the transfer is complete. Downloads
+// of unknown size get a final line with the total and average speed.
+func (pr *ProgressReader) finish() {
+	switch {
+	case pr.callback != nil:
+		// The callback already had the last update, there is nothing to end
+	case pr.total > 0:
+		pr.logger.EndProgress()
+	case pr.total < 0:
+		var speed float64
+		if elapsed := time.Since(pr.startTime).Seconds(); elapsed > 0 {
+			speed = float64(pr.downloaded-pr.offset) / elapsed
+		}
+		pr.logger.LogTransferTotal(pr.downloaded, speed)
+	}
+}
+
+// findPartialDownload returns the file to write into and how many bytes of it
+// already exist. A leftover .part file is always resumed; the target file
+// itself is only resumed when resumeExisting is set.
+func findPartialDownload(outputPath string, resumeExisting bool) (string, int64) {
+	partPath := outputPath + ".part"
+	if info, err := os.Stat(partPath); err == nil && info.Mode().IsRegular() {
+		return partPath, info.Size()
+	}
+
+	if resumeExisting {
+		if info, err := os.Stat(outputPath); err == nil && info.Mode().IsRegular() {
+			return outputPath, info.Size()
+		}
+	}
+
+	return partPath, 0
+}
+
+// finishPartialDownload renames a completed partial file to its final name,
+// first backing up the file it replaces when backups is positive
+func finishPartialDownload(partPath, outputPath string, backups int) error {
+	if partPath == outputPath {
+		return nil
+	}
+	if err := rotateBackups(outputPath, backups); err != nil {
+		return err
+	}
+	if err := os.Rename(partPath, outputPath); err != nil {
+		return &WriteError{Op: fmt.Sprintf("move %s into place", partPath), Err: err}
+	}
+	removeValidator(partPath)
+	return nil
+}
+
+// localFileModTime returns the modification time of an existing output file
+// when timestamping is enabled
+func localFileModTime(outputPath string, timestamping bool) (time.Time, bool) {
+	if !timestamping {
+		return time.Time{}, false
+	}
+	info, err := os.Stat(outputPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
+// serverModTime returns the Last-Modified time from the response headers when
+// timestamping or PreserveTimestamp is enabled, or the zero time if both are
+// disabled or the header is missing or unparseable
+func serverModTime(header http.Header, options *Options) time.Time {
+	if !options.Timestamping && !options.PreserveTimestamp {
+		return time.Time{}
+	}
+	modTime, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return modTime
+}
+
+// serverFilename returns the sanitized file name from the Content-Disposition
+// header or, with TrustServerNames, from the URL the response finally came
+// from after redirects. It returns "" when neither applies or an explicit -O
+// name was given.
+func serverFilename(resp *http.Response, options *Options) string {
+	if options.OutputName != "" {
+		return ""
+	}
+
+	// ParseMediaType decodes RFC 2231 filename* parameters into "filename"
+	if disposition := resp.Header.Get("Content-Disposition"); disposition != "" {
+		if _, params, err := mime.ParseMediaType(disposition); err == nil {
+			if name := sanitizeFilename(params["filename"]); name != "" {
+				return name
+			}
+		}
+	}
+
+	if options.TrustServerNames && resp.Request != nil {
+		return sanitizeFilename(path.Base(resp.Request.URL.Path))
+	}
+	return ""
+}
+
+// sanitizeFilename strips any directory components and control characters so
+// a server-supplied name cannot escape the target directory
+func sanitizeFilename(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	return name
+}
+
 // determineOutputPath determines where to save the downloaded file
 func determineOutputPath(urlStr string, parsedURL *url.URL, options *Options) (string, error) {
 	var filename string
@@ -209,63 +709,3 @@ func determineOutputPath(urlStr string, parsedURL *url.URL, options *Options) (s
 	// Default to current directory
 	return filepath.Join(".", filename), nil
 }
-
-// parseRateLimit parses rate limit string (e.g., "400k", "2M") into rate.Limiter
-func parseRateLimit(rateStr string) (*rate.Limiter, error) {
-	rateStr = strings.TrimSpace(strings.ToLower(rateStr))
-	if rateStr == "" {
-		return nil, fmt.Errorf("empty rate limit")
-	}
-
-	// Extract number and unit
-	var numStr string
-	var unit string
-
-	for i, r := range rateStr {
-		if r >= '0' && r <= '9' || r == '.' {
-			numStr += string(r)
-		} else {
-			unit = rateStr[i:]
-			break
-		}
-	}
-
-	if numStr == "" {
-		return nil, fmt.Errorf("no number found in rate limit")
-	}
-
-	// Parse the number
-	num, err := strconv.ParseFloat(numStr, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid number in rate limit: %v", err)
-	}
-
-	// Convert to bytes per second based on unit
-	var bytesPerSecond float64
-	switch unit {
-	case "", "b":
-		bytesPerSecond = num
-	case "k", "kb":
-		bytesPerSecond = num * 1024
-	case "m", "mb":
-		bytesPerSecond = num * 1024 * 1024
-	case "g", "gb":
-		bytesPerSecond = num * 1024 * 1024 * 1024
-	default:
-		return nil, fmt.Errorf("unknown unit in rate limit: %s", unit)
-	}
-
-	if bytesPerSecond <= 0 {
-		return nil, fmt.Errorf("rate limit must be positive")
-	}
-
-	// Create rate limiter
-	// For very low rates, we need a burst size that can handle typical read sizes
-	// but still respect the overall rate limit
-	burstSize := int(bytesPerSecond * 2) // Allow 2 seconds worth of data as burst
-	if burstSize < 32768 {               // Minimum 32KB burst to handle all buffer sizes
-		burstSize = 32768
-	}
-
-	return rate.NewLimiter(rate.Limit(bytesPerSecond), burstSize), nil
-}
@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// StatusError reports an HTTP response whose status code means the file
+// could not be downloaded
+type StatusError struct {
+	Code   int
+	Status string // Status line text, e.g. "404 Not Found"
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned status: %s", e.Status)
+}
+
+// NetworkError reports a failure to reach the server or to receive its
+// response, such as a DNS, connection, TLS or timeout error
+type NetworkError struct {
+	Op  string // What was being attempted, e.g. "make request"
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("failed to %s: %v", e.Op, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError reports a failure to store the download on disk, such as a full
+// disk or missing permissions
+type WriteError struct {
+	Op  string // What was being attempted, e.g. "create file"
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("failed to %s: %v", e.Op, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// outputWriter tags write errors of the destination as WriteErrors, so a
+// failed copy can be told apart from a failed read of the response body
+type outputWriter struct {
+	w  io.Writer
+	op string // Reported as the WriteError's Op
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		err = &WriteError{Op: w.op, Err: err}
+	}
+	return n, err
+}
+
+// copyToFile copies src into file. Write failures come back as WriteErrors
+// and read failures as NetworkErrors.
+func copyToFile(file *os.File, src io.Reader) (int64, error) {
+	return copyToWriter(outputWriter{file, "write file"}, src)
+}
+
+// copyToWriter copies src into an outputWriter, reporting read failures as
+// NetworkErrors
+func copyToWriter(w outputWriter, src io.Reader) (int64, error) {
+	written, err := io.Copy(w, src)
+	var writeErr *WriteError
+	if err != nil && !errors.As(err, &writeErr) {
+		err = &NetworkError{Op: "read response", Err: err}
+	}
+	return written, err
+}
@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+	"wget/internal/httpclient"
+	"wget/internal/logging"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// defaultFTPPort is used when the URL does not name a port
+const defaultFTPPort = "21"
+
+// downloadFTP retrieves a single file over FTP into partPath, resuming from
+// offset, and moves it to outputPath when complete. Credentials come from the
+// URL, then --user/--password, and fall back to an anonymous login. interrupt
+// is the caller's context, see removeInterruptedPart.
+func downloadFTP(ctx, interrupt context.Context, cancel context.CancelFunc, parsedURL *url.URL, outputPath, partPath string,
+	offset int64, checksum *Checksum, options *Options, result *Result, logger *logging.Logger) error {
+	host := parsedURL.Host
+	if parsedURL.Port() == "" {
+		host = net.JoinHostPort(parsedURL.Hostname(), defaultFTPPort)
+	}
+
+	connectTimeout := options.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = httpclient.DefaultConnectTimeout
+	}
+	dialer := net.Dialer{Timeout: connectTimeout}
+	if options.BindAddress != "" {
+		localAddr, err := httpclient.ParseBindAddress(options.BindAddress)
+		if err != nil {
+			return err
+		}
+		dialer.LocalAddr = localAddr
+	}
+	dial := httpclient.DialContext(&dialer, options.Network)
+	conn, err := ftp.Dial(host, ftp.DialWithDialer(dialer), ftp.DialWithDialFunc(func(network, addr string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	}))
+	if err != nil {
+		return &NetworkError{Op: "connect to " + host, Err: err}
+	}
+	defer conn.Quit()
+
+	user, password := ftpCredentials(parsedURL, options)
+	if err := conn.Login(user, password); err != nil {
+		return &NetworkError{Op: "log in as " + user, Err: err}
+	}
+
+	// SIZE is optional, so a failure only means there is no progress total
+	remotePath := parsedURL.Path
+	contentLength, err := conn.FileSize(remotePath)
+	if err != nil {
+		contentLength = -1
+	}
+	logger.LogContentSize(contentLength)
+
+	// The partial file already holds the whole resource
+	if offset > 0 && offset == contentLength {
+		return completeExistingPart(parsedURL.String(), partPath, outputPath, checksum, time.Time{}, options, result, logger)
+	}
+
+	remaining := contentLength
+	if contentLength > 0 {
+		remaining -= offset
+	}
+	if err := options.Quota.reserve(remaining, result); err != nil {
+		return err
+	}
+
+	resp, err := conn.RetrFrom(remotePath, uint64(offset))
+	if err != nil {
+		return &NetworkError{Op: "retrieve " + remotePath, Err: err}
+	}
+	defer resp.Close()
+
+	if offset > 0 {
+		logger.LogResuming(offset)
+	}
+	logger.LogSavingTo(outputPath)
+
+	file, err := openPartFile(outputPath, partPath, offset, checksum)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The data connection doesn't watch the context, so expire its read
+	// deadline once the download is cancelled or stalls
+	stop := context.AfterFunc(ctx, func() {
+		resp.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	// Abort the transfer if the server stops sending data
+	body := httpclient.NewIdleReader(resp, options.ReadTimeout, cancel)
+	defer body.Stop()
+
+	written, err := copyToPartFile(ctx, interrupt, file, body, partPath, offset, contentLength, -1, checksum, options, logger)
+	result.BytesWritten = written
+	if err != nil {
+		return err
+	}
+	return completeDownload(parsedURL.String(), partPath, outputPath, checksum, time.Time{}, options.Backups, result, logger)
+}
+
+// ftpCredentials returns the login for an FTP URL
+func ftpCredentials(parsedURL *url.URL, options *Options) (string, string) {
+	if parsedURL.User != nil {
+		password, _ := parsedURL.User.Password()
+		return parsedURL.User.Username(), password
+	}
+	if options.User != "" {
+		return options.User, options.Password
+	}
+	if user, password, ok := options.Netrc.Credentials(parsedURL.Hostname()); ok {
+		return user, password
+	}
+	return "anonymous", "anonymous"
+}
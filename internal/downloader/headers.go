@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Values of Options.SaveHeaders
+const (
+	SaveHeadersInline  = "inline"  // Write the headers at the start of the saved file, as GNU wget does
+	SaveHeadersSidecar = "sidecar" // Write the headers to a separate <file>.headers file
+)
+
+// HeadersFileSuffix is appended to the output path to name the sidecar file
+const HeadersFileSuffix = ".headers"
+
+// formatResponseHeaders renders the status line and headers of resp as they
+// appear on the wire, ending with the blank line that separates them from the
+// body
+func formatResponseHeaders(resp *http.Response) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\r\n", resp.Proto, resp.Status)
+	// net/http moves Transfer-Encoding out of the header map
+	if len(resp.TransferEncoding) > 0 {
+		fmt.Fprintf(&b, "Transfer-Encoding: %s\r\n", strings.Join(resp.TransferEncoding, ", "))
+	}
+	resp.Header.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// writeHeadersFile saves the response headers next to the downloaded file
+func writeHeadersFile(outputPath string, resp *http.Response) error {
+	if err := os.WriteFile(outputPath+HeadersFileSuffix, formatResponseHeaders(resp), 0644); err != nil {
+		return &WriteError{Op: "save headers", Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+	"wget/internal/logging"
+)
+
+// downloadLocalFile copies the file named by a file:// URL into partPath,
+// resuming from offset, and moves it to outputPath when complete. The copy
+// goes through the same rate limiting, progress and checksum handling as a
+// network download. interrupt is the caller's context, see
+// removeInterruptedPart.
+func downloadLocalFile(ctx, interrupt context.Context, parsedURL *url.URL, outputPath, partPath string,
+	offset int64, checksum *Checksum, options *Options, result *Result, logger *logging.Logger) error {
+	if parsedURL.Host != "" && parsedURL.Host != "localhost" {
+		return fmt.Errorf("file URLs on other hosts are not supported: %s", parsedURL.Host)
+	}
+	sourcePath := filepath.FromSlash(parsedURL.Path)
+
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", sourcePath, err)
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", sourcePath, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", sourcePath)
+	}
+
+	// The source's modification time stands in for Last-Modified
+	var modTime time.Time
+	if options.Timestamping || options.PreserveTimestamp {
+		modTime = info.ModTime()
+	}
+	if options.Timestamping {
+		if localModTime, ok := localFileModTime(outputPath, true); ok && !modTime.After(localModTime) {
+			logger.LogNotModified(outputPath)
+			result.OutputPath, result.Skipped = outputPath, true
+			return nil
+		}
+	}
+
+	contentLength := info.Size()
+	if !info.Mode().IsRegular() {
+		// Pipes and devices have no meaningful size
+		contentLength = -1
+	}
+	logger.LogContentSize(contentLength)
+
+	// The partial file already holds the whole source
+	if offset > 0 && offset == contentLength {
+		return completeExistingPart(parsedURL.String(), partPath, outputPath, checksum, modTime, options, result, logger)
+	}
+
+	// Resume where the partial file ends, or start over when the source is
+	// now shorter than the partial file or can't seek
+	if offset > 0 {
+		if contentLength < offset {
+			offset = 0
+		} else if _, err := source.Seek(offset, io.SeekStart); err != nil {
+			offset = 0
+		}
+	}
+
+	remaining := contentLength
+	if contentLength > 0 {
+		remaining -= offset
+	}
+	if err := options.Quota.reserve(remaining, result); err != nil {
+		return err
+	}
+
+	if offset > 0 {
+		logger.LogResuming(offset)
+	}
+	logger.LogSavingTo(outputPath)
+
+	file, err := openPartFile(outputPath, partPath, offset, checksum)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	written, err := copyToPartFile(ctx, interrupt, file, source, partPath, offset, contentLength, -1, checksum, options, logger)
+	result.BytesWritten = written
+	if err != nil {
+		return err
+	}
+	return completeDownload(parsedURL.String(), partPath, outputPath, checksum, modTime, options.Backups, result, logger)
+}
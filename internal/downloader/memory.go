@@ -0,0 +1,33 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"wget/internal/logging"
+)
+
+// DefaultMaxInMemory caps DownloadBytes when Options.MaxInMemory is 0
+const DefaultMaxInMemory = 64 << 20
+
+// DownloadBytes fetches an HTTP(S) URL into memory like DownloadTo.
+// Responses larger than options.MaxInMemory are refused, up front when the
+// server reports the size and otherwise once the limit is crossed. The limit
+// is only available to library callers; the command line has no flag for it.
+func DownloadBytes(ctx context.Context, urlStr string, options *Options, logger *logging.Logger) ([]byte, *Result, error) {
+	maxSize := options.MaxInMemory
+	if maxSize < 0 {
+		return nil, nil, fmt.Errorf("invalid in-memory size limit: %d", maxSize)
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxInMemory
+	}
+
+	var buf bytes.Buffer
+	result, err := downloadToWriter(ctx, urlStr, &buf, maxSize, options, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), result, nil
+}
@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// claimedNames are output files that downloads in progress are going to
+// write, so concurrent downloads numbering their files pick different names
+var claimedNames = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// nameClaim is the output file reserved by one download with
+// Options.NumberDuplicates
+type nameClaim struct {
+	path string
+}
+
+// numberDuplicates reports whether a download numbers its file instead of
+// replacing an existing one. No-clobber, resuming, timestamping and backups
+// each have their own rule for existing files.
+func numberDuplicates(options *Options) bool {
+	return options.NumberDuplicates && !options.NoClobber && !options.Continue && !options.Timestamping && options.Backups == 0
+}
+
+// claim reserves outputPath, or when that file exists or is claimed by another
+// download, the first free name of outputPath.1, outputPath.2 and so on. Any
+// name claimed before is released.
+func (c *nameClaim) claim(outputPath string) string {
+	claimedNames.Lock()
+	defer claimedNames.Unlock()
+	if c.path != "" {
+		delete(claimedNames.paths, c.path)
+	}
+
+	candidate := outputPath
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) && !claimedNames.paths[candidate] {
+			break
+		}
+		candidate = fmt.Sprintf("%s.%d", outputPath, n)
+	}
+	claimedNames.paths[candidate] = true
+	c.path = candidate
+	return candidate
+}
+
+// release frees the claimed name once the download has finished with it
+func (c *nameClaim) release() {
+	claimedNames.Lock()
+	defer claimedNames.Unlock()
+	delete(claimedNames.paths, c.path)
+	c.path = ""
+}
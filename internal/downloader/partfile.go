@@ -0,0 +1,128 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"wget/internal/logging"
+	"wget/internal/ratelimit"
+
+	"golang.org/x/time/rate"
+)
+
+// openPartFile creates the directory of outputPath and opens partPath for
+// writing, appending after the offset bytes already there when resuming and
+// truncating it otherwise. Resumed bytes are added to checksum.
+func openPartFile(outputPath, partPath string, offset int64, checksum *Checksum) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return nil, &WriteError{Op: "create directory", Err: err}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return nil, &WriteError{Op: "create file", Err: err}
+	}
+
+	// Include the bytes already on disk in the digest when resuming
+	if checksum != nil && offset > 0 {
+		if err := checksum.addFile(partPath); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read %s: %v", partPath, err)
+		}
+	}
+	return file, nil
+}
+
+// copyToPartFile copies body into a file from openPartFile with copyBody,
+// then closes the file. On failure the partial file is kept for resuming
+// unless the caller's interrupt context was cancelled, see
+// removeInterruptedPart.
+func copyToPartFile(ctx, interrupt context.Context, file *os.File, body io.Reader, partPath string,
+	offset, total, expected int64, checksum *Checksum, options *Options, logger *logging.Logger) (int64, error) {
+	written, err := copyBody(ctx, outputWriter{file, "write file"}, body, offset, total, expected, checksum, options, logger)
+	if err != nil {
+		file.Close()
+		removeInterruptedPart(interrupt, partPath, options)
+		return written, err
+	}
+	if err := file.Close(); err != nil {
+		return written, &WriteError{Op: "close file", Err: err}
+	}
+	return written, nil
+}
+
+// copyBody copies a response body into w behind the rate limit and progress
+// display, hashing it into checksum. It is the transfer shared by downloads
+// to a file and by DownloadTo. offset is the number of bytes already held
+// from an earlier run and total the full size of the download including
+// them, -1 when unknown. Unless it is negative or options.IgnoreLength is
+// set, expected is the number of bytes body must deliver.
+func copyBody(ctx context.Context, w outputWriter, body io.Reader, offset, total, expected int64,
+	checksum *Checksum, options *Options, logger *logging.Logger) (int64, error) {
+	var limiter *rate.Limiter
+	if options.RateLimit != "" {
+		var err error
+		limiter, err = ratelimit.Parse(options.RateLimit)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rate limit: %v", err)
+		}
+	}
+
+	progressReader := &ProgressReader{
+		reader:     ratelimit.NewReader(ctx, body, limiter),
+		total:      total,
+		downloaded: offset,
+		offset:     offset,
+		lastUpdate: time.Now(),
+		startTime:  time.Now(),
+		speed:      newSpeedWindow(time.Now(), offset),
+		logger:     logger,
+		checksum:   checksum,
+		counter:    options.Progress,
+		callback:   options.ProgressFunc,
+	}
+	// Count bytes resumed from disk toward the shared total
+	if options.Progress != nil {
+		options.Progress.Add(offset)
+	}
+
+	written, err := copyToWriter(w, progressReader)
+	if options.IgnoreLength && errors.Is(err, io.ErrUnexpectedEOF) {
+		// The body ended before the advertised length, accept what arrived
+		err = nil
+	}
+	if err != nil {
+		return written, err
+	}
+	if !options.IgnoreLength && expected >= 0 && written != expected {
+		return written, &NetworkError{
+			Op:  "read response",
+			Err: fmt.Errorf("received %d bytes, expected %d", written, expected),
+		}
+	}
+
+	progressReader.finish()
+	return written, nil
+}
+
+// completeExistingPart finishes a download whose partial file already holds
+// the whole resource, so there is nothing left to transfer
+func completeExistingPart(urlStr, partPath, outputPath string, checksum *Checksum, modTime time.Time,
+	options *Options, result *Result, logger *logging.Logger) error {
+	if checksum != nil {
+		if err := checksum.addFile(partPath); err != nil {
+			return fmt.Errorf("failed to read %s: %v", partPath, err)
+		}
+	}
+	logger.LogSavingTo(outputPath)
+	return completeDownload(urlStr, partPath, outputPath, checksum, modTime, options.Backups, result, logger)
+}
@@ -1,3 +1,41 @@
 package downloader
 
-// This file is currently unused - all progress functionality is implemented in downloader.go
+import "time"
+
+// speedWindowSpan is how far back the displayed speed and ETA look, so they
+// follow changes in the connection without jumping on every read
+const speedWindowSpan = 5 * time.Second
+
+// speedSample is the byte count reached at a point in time
+type speedSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// speedWindow measures the transfer speed over the last speedWindowSpan
+type speedWindow struct {
+	samples []speedSample
+}
+
+// newSpeedWindow starts measuring from bytes already transferred at start
+func newSpeedWindow(start time.Time, bytes int64) *speedWindow {
+	return &speedWindow{samples: []speedSample{{start, bytes}}}
+}
+
+// add records the byte count reached at now and returns the speed in bytes
+// per second across the window
+func (w *speedWindow) add(now time.Time, bytes int64) float64 {
+	w.samples = append(w.samples, speedSample{now, bytes})
+
+	// Drop old samples, keeping one at or before the start of the window
+	for len(w.samples) > 2 && now.Sub(w.samples[1].at) >= speedWindowSpan {
+		w.samples = w.samples[1:]
+	}
+
+	first := w.samples[0]
+	elapsed := now.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes-first.bytes) / elapsed
+}
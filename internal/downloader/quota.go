@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"fmt"
+	"sync"
+
+	"wget/internal/logging"
+)
+
+// Quota caps the bytes fetched by the downloads sharing it. A download is
+// refused when its known size doesn't fit in what is left; one of unknown
+// size is started as long as the quota isn't used up. A nil Quota is
+// unlimited.
+type Quota struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64 // Bytes received plus those reserved by running downloads
+}
+
+// NewQuota returns a quota of limit bytes, or nil for a limit of 0
+func NewQuota(limit int64) *Quota {
+	if limit <= 0 {
+		return nil
+	}
+	return &Quota{limit: limit}
+}
+
+// QuotaError reports a download refused because it would exceed the quota
+type QuotaError struct {
+	Limit int64
+	Used  int64
+	Size  int64 // Bytes the download needed, -1 when unknown
+}
+
+func (e *QuotaError) Error() string {
+	if e.Size < 0 {
+		return fmt.Sprintf("download quota of %s reached, not starting download", logging.FormatBytes(e.Limit))
+	}
+	return fmt.Sprintf("download quota of %s exceeded: file needs %s but only %s is left",
+		logging.FormatBytes(e.Limit), logging.FormatBytes(e.Size), logging.FormatBytes(e.Limit-e.Used))
+}
+
+// reserve claims size bytes of the quota for a download, -1 when the size is
+// unknown, recording the claim in result for settle
+func (q *Quota) reserve(size int64, result *Result) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.used >= q.limit || (size > 0 && q.used+size > q.limit) {
+		return &QuotaError{Limit: q.limit, Used: q.used, Size: size}
+	}
+	if size > 0 {
+		q.used += size
+		result.quotaReserved = size
+	}
+	return nil
+}
+
+// settle replaces the claim of a download that ended, whether it succeeded
+// or not, with the bytes it actually received
+func (q *Quota) settle(result *Result) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used += result.BytesWritten - result.quotaReserved
+	result.quotaReserved = 0
+}
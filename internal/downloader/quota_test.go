@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// truncatingHandler advertises size bytes but drops the connection after
+// sending sent of them. Range requests are answered from the given offset.
+func truncatingHandler(size, sent int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offset := 0
+		if value, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes="); ok {
+			offset, _ = strconv.Atoi(strings.TrimSuffix(value, "-"))
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(size-offset))
+		if offset > 0 {
+			w.Header().Set("Content-Range", "bytes "+strconv.Itoa(offset)+"-"+strconv.Itoa(size-1)+"/"+strconv.Itoa(size))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		w.Write([]byte(strings.Repeat("x", min(sent, size)-offset)))
+		w.(http.Flusher).Flush()
+		if sent < size {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// TestQuotaCountsFailedDownloads checks that bytes received before a
+// download fails are charged to the quota, and that resuming charges only
+// the rest
+func TestQuotaCountsFailedDownloads(t *testing.T) {
+	dir := t.TempDir()
+	quota := NewQuota(10000)
+	options := &Options{OutputPath: dir, OutputName: "file", Tries: 1, Continue: true, Quota: quota}
+
+	server := httptest.NewServer(truncatingHandler(1000, 400))
+	if _, err := DownloadFileResult(context.Background(), server.URL+"/file", options, nil); err == nil {
+		t.Fatal("truncated download succeeded")
+	}
+	server.Close()
+	if quota.used != 400 {
+		t.Errorf("after a failure quota used = %d, want 400", quota.used)
+	}
+
+	server = httptest.NewServer(truncatingHandler(1000, 1000))
+	defer server.Close()
+	result, err := DownloadFileResult(context.Background(), server.URL+"/file", options, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.BytesWritten != 600 {
+		t.Errorf("resumed download wrote %d bytes, want 600", result.BytesWritten)
+	}
+	if quota.used != 1000 {
+		t.Errorf("after resuming quota used = %d, want 1000", quota.used)
+	}
+}
@@ -0,0 +1,92 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+	"wget/internal/httpclient"
+	"wget/internal/logging"
+)
+
+const (
+	initialBackoff = 1 * time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// doWithRetry sends the request, retrying connection errors, timeouts, 5xx and
+// 429 responses with exponential backoff. A Retry-After header on a 429 or 503
+// replaces the backoff for that attempt. A tries value of 0 retries forever.
+// When the last attempt still gets one of these statuses, that response is
+// returned so the caller can report the status as usual.
+func doWithRetry(client *http.Client, req *http.Request, tries int, logger *logging.Logger) (*http.Response, error) {
+	backoff := initialBackoff
+
+	for attempt := 1; ; attempt++ {
+		attemptReq := req.Clone(req.Context())
+		if req.GetBody != nil {
+			// Each attempt needs a fresh copy of the request body
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+		resp, err := client.Do(attemptReq)
+
+		lastAttempt := tries > 0 && attempt >= tries
+		wait := backoff
+		if err != nil {
+			err = logging.RedactError(err)
+			if lastAttempt || !isRetryableError(err) {
+				return nil, err
+			}
+		} else {
+			if !isRetryableStatus(resp.StatusCode) || lastAttempt {
+				return resp, nil
+			}
+			err = fmt.Errorf("server returned status: %s", resp.Status)
+			if retryAfter, ok := httpclient.RetryAfter(resp); ok {
+				wait = retryAfter
+			}
+			resp.Body.Close()
+		}
+
+		logger.LogRetry(attempt, tries, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// isRetryableStatus reports whether a response status is worth retrying: a
+// server error or a request to slow down
+func isRetryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// isRetryableError reports whether a request error is a transient network failure
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var redirectErr *httpclient.RedirectError
+	if errors.As(err, &redirectErr) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
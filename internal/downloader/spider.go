@@ -0,0 +1,54 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"wget/internal/logging"
+)
+
+// checkRemoteFile implements --spider: it asks the server whether urlStr
+// exists without saving anything. Servers that reject HEAD are asked again
+// with a GET whose body is never read.
+func checkRemoteFile(ctx context.Context, client *http.Client, urlStr string, options *Options, result *Result, logger *logging.Logger) error {
+	logger.Printf("Spider mode enabled. Check if remote file exists.\n")
+
+	resp, err := spiderRequest(ctx, client, http.MethodHead, urlStr, options, logger)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		resp, err = spiderRequest(ctx, client, http.MethodGet, urlStr, options, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.LogStatus(resp.Status)
+	if options.ServerResponse {
+		logger.LogServerResponse(resp)
+	}
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("remote file does not exist -- broken link: %w", &StatusError{Code: resp.StatusCode, Status: resp.Status})
+	}
+
+	logger.LogContentSize(resp.ContentLength)
+	logger.Printf("Remote file exists.\n")
+	logger.LogFinish()
+	return nil
+}
+
+// spiderRequest sends a request for its status line and headers only
+func spiderRequest(ctx context.Context, client *http.Client, method, urlStr string, options *Options, logger *logging.Logger) (*http.Response, error) {
+	req, err := newRequest(ctx, method, urlStr, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := doWithRetry(client, req, options.Tries, logger)
+	if err != nil {
+		return nil, &NetworkError{Op: "make request", Err: err}
+	}
+	resp.Body.Close()
+	return resp, nil
+}
@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"wget/internal/httpclient"
+	"wget/internal/logging"
+)
+
+// DownloadTo streams an HTTP(S) URL into w instead of a file, with the same
+// retries, rate limit, progress, quota and checksum handling as
+// DownloadFileResult. Options that only concern files on disk, such as
+// Continue or Chunks, are ignored. Failures of w come back as a *WriteError.
+// On a failed or mismatching download w may already hold part of the body. A
+// nil logger discards the progress output.
+func DownloadTo(ctx context.Context, urlStr string, w io.Writer, options *Options, logger *logging.Logger) (*Result, error) {
+	return downloadToWriter(ctx, urlStr, w, 0, options, logger)
+}
+
+// downloadToWriter runs streamResponse for DownloadTo and DownloadBytes,
+// timing the download and settling the quota
+func downloadToWriter(ctx context.Context, urlStr string, w io.Writer, maxSize int64, options *Options, logger *logging.Logger) (*Result, error) {
+	if logger == nil {
+		logger = logging.NewDiscardLogger()
+	}
+
+	result := &Result{}
+	startTime := time.Now()
+	err := streamResponse(ctx, urlStr, w, maxSize, options, result, logger)
+	result.Elapsed = time.Since(startTime)
+	options.Quota.settle(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// streamResponse copies the body of urlStr into w, filling in result. A
+// positive maxSize refuses bodies larger than that many bytes.
+func streamResponse(ctx context.Context, urlStr string, w io.Writer, maxSize int64, options *Options, result *Result, logger *logging.Logger) error {
+	logger.LogStart()
+
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q in %s (supported: http, https)", parsedURL.Scheme, logging.RedactURL(parsedURL.String()))
+	}
+
+	var checksum *Checksum
+	if options.Checksum != "" {
+		checksum, err = ParseChecksum(options.Checksum)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	client, err := newClient(options)
+	if err != nil {
+		return err
+	}
+	resp, err := sendRequest(ctx, client, urlStr, nil, options, result, logger)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !isFullResponse(resp.StatusCode) {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	// Refuse a response known to be too large before reading any of it
+	contentLength := resp.ContentLength
+	if options.IgnoreLength {
+		contentLength = -1
+	}
+	if maxSize > 0 && contentLength > maxSize {
+		return fmt.Errorf("response of %s exceeds the in-memory limit of %s",
+			logging.FormatBytes(contentLength), logging.FormatBytes(maxSize))
+	}
+	if err := options.Quota.reserve(contentLength, result); err != nil {
+		return err
+	}
+	logger.LogContentSize(contentLength)
+
+	// Abort the transfer if the server stops sending data
+	body := httpclient.NewIdleReader(resp.Body, options.ReadTimeout, cancel)
+	defer body.Stop()
+
+	// Read one byte past the limit to tell a body of exactly maxSize bytes
+	// from a larger one
+	var reader io.Reader = body
+	if maxSize > 0 {
+		reader = io.LimitReader(body, maxSize+1)
+	}
+	read, err := copyBody(ctx, outputWriter{w, "write output"}, reader, 0, contentLength, resp.ContentLength, checksum, options, logger)
+	// Bytes received before a failure still count toward the quota
+	result.BytesWritten = read
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && read > maxSize {
+		return fmt.Errorf("response exceeds the in-memory limit of %s", logging.FormatBytes(maxSize))
+	}
+
+	if checksum != nil {
+		if err := checksum.Verify(); err != nil {
+			return err
+		}
+		logger.Printf("checksum OK: %s:%s\n", checksum.Algorithm, checksum.Sum())
+		result.Checksum = checksum.Sum()
+	}
+
+	logger.LogDownloaded(urlStr)
+	logger.LogFinish()
+	return nil
+}
@@ -0,0 +1,68 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDownloadToMatchesFileDownload sends the same responses to DownloadTo
+// and DownloadFileResult, which share one transfer path, and checks they
+// agree on what succeeds and what is received
+func TestDownloadToMatchesFileDownload(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+		wantErr any
+	}{
+		{"ok", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		}, "hello", nil},
+		{"non-authoritative", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNonAuthoritativeInfo)
+			w.Write([]byte("proxied"))
+		}, "proxied", nil},
+		{"not found", func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		}, "", new(*StatusError)},
+		{"truncated", truncatingHandler(1000, 400), "", new(*NetworkError)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			var buf bytes.Buffer
+			_, streamErr := DownloadTo(context.Background(), server.URL+"/file", &buf, &Options{Tries: 1}, nil)
+
+			dir := t.TempDir()
+			_, fileErr := DownloadFileResult(context.Background(), server.URL+"/file",
+				&Options{OutputPath: dir, OutputName: "file", Tries: 1}, nil)
+
+			for name, err := range map[string]error{"DownloadTo": streamErr, "DownloadFileResult": fileErr} {
+				if tt.wantErr == nil && err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+				if tt.wantErr != nil && !errors.As(err, tt.wantErr) {
+					t.Errorf("%s error = %v, want %T", name, err, tt.wantErr)
+				}
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if buf.String() != tt.want {
+				t.Errorf("DownloadTo wrote %q, want %q", buf.String(), tt.want)
+			}
+			if content, _ := os.ReadFile(filepath.Join(dir, "file")); string(content) != tt.want {
+				t.Errorf("DownloadFileResult saved %q, want %q", content, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// validatorSuffix names the file next to a .part file that records which
+// version of the resource the partial bytes came from
+const validatorSuffix = ".validator"
+
+// resumeValidator returns the value to send as If-Range when resuming a
+// download of the response: its strong ETag, or failing that its
+// Last-Modified time. Weak ETags can't be used with If-Range.
+func resumeValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// saveValidator records the validator of the response being written to
+// partPath, so a later resume only appends bytes of the same version. Nothing
+// is recorded when the download writes straight into outputPath.
+func saveValidator(partPath, outputPath string, header http.Header) {
+	if partPath == outputPath {
+		return
+	}
+	validator := resumeValidator(header)
+	if validator == "" {
+		os.Remove(partPath + validatorSuffix)
+		return
+	}
+	// Without a record the resume falls back to a plain range request
+	os.WriteFile(partPath+validatorSuffix, []byte(validator), 0644)
+}
+
+// loadValidator returns the validator recorded for partPath, or "" if there
+// is none
+func loadValidator(partPath string) string {
+	data, err := os.ReadFile(partPath + validatorSuffix)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// removeValidator deletes the record kept for partPath once the partial file
+// is gone
+func removeValidator(partPath string) {
+	os.Remove(partPath + validatorSuffix)
+}
@@ -0,0 +1,198 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// DefaultConnectTimeout is used when no connect timeout is configured
+const DefaultConnectTimeout = 30 * time.Second
+
+const (
+	// DefaultMaxRedirects is the redirect limit when none is configured
+	DefaultMaxRedirects = 20
+	// NoRedirects as MaxRedirects treats any redirect as an error
+	NoRedirects = -1
+)
+
+type Options struct {
+	ConnectTimeout time.Duration // Time allowed to establish a connection
+	ReadTimeout    time.Duration // Idle time allowed between reads, 0 means no limit
+	Proxy          string        // Proxy URL overriding the environment (http, https or socks5)
+	BindAddress    string        // Local IP address connections originate from, "" lets the system choose
+	Network        string        // "tcp4" or "tcp6" to connect only over IPv4 or IPv6, "" for either
+
+	NoCheckCertificate bool           // Skip TLS certificate verification
+	Certificate        string         // PEM client certificate file for servers that require mutual TLS
+	PrivateKey         string         // PEM private key file for Certificate, "" when Certificate holds both
+	CACertificate      string         // PEM file of CA certificates trusted alongside the system ones
+	Jar                http.CookieJar // Cookie jar shared between clients, may be nil
+	MaxRedirects       int            // Longest redirect chain followed, 0 uses DefaultMaxRedirects
+
+	// MaxIdleConnsPerHost is the number of idle connections kept open to each
+	// host for reuse, 0 uses http.DefaultMaxIdleConnsPerHost
+	MaxIdleConnsPerHost int
+}
+
+// New creates an HTTP client whose transport applies the configured timeouts.
+// The client itself has no overall deadline so long downloads are not cut off;
+// body reads are guarded separately with NewIdleReader.
+func New(options *Options) (*http.Client, error) {
+	connectTimeout := options.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	if options.BindAddress != "" {
+		localAddr, err := ParseBindAddress(options.BindAddress)
+		if err != nil {
+			return nil, err
+		}
+		dialer.LocalAddr = localAddr
+	}
+
+	tlsConfig, err := tlsConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           DialContext(dialer, options.Network),
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: options.ReadTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   options.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+
+	if options.Proxy != "" {
+		if err := configureProxy(transport, dialer, options.Network, options.Proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	return &http.Client{
+		Transport:     transport,
+		Jar:           options.Jar,
+		CheckRedirect: checkRedirect(options.MaxRedirects),
+	}, nil
+}
+
+// ParseBindAddress checks that addr is an IP address of this machine, which
+// outgoing connections can be bound to, and returns it as a local address
+func ParseBindAddress(addr string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IP address", addr)
+	}
+	// Binding a listener is the simplest way to ask whether the address is assignable
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not assignable: %v", addr, err)
+	}
+	listener.Close()
+	return &net.TCPAddr{IP: ip}, nil
+}
+
+// checkRedirect returns a redirect policy that stops after maxRedirects
+// redirects, or as soon as a redirect leads back to a URL already in the
+// chain, which would otherwise cycle until the limit
+func checkRedirect(maxRedirects int) func(req *http.Request, via []*http.Request) error {
+	switch {
+	case maxRedirects == 0:
+		maxRedirects = DefaultMaxRedirects
+	case maxRedirects < 0:
+		maxRedirects = 0
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return &RedirectError{URL: req.URL.String(), Max: maxRedirects}
+		}
+		for _, prev := range via {
+			if prev.URL.String() == req.URL.String() {
+				return &RedirectError{URL: req.URL.String(), Loop: true}
+			}
+		}
+		return nil
+	}
+}
+
+// RedirectError reports a redirect chain that was given up on. Following it
+// again would end the same way, so it isn't worth retrying.
+type RedirectError struct {
+	URL  string // Where the last redirect pointed
+	Loop bool   // The chain led back to a URL it had already visited
+	Max  int    // The redirect limit, when it was exceeded
+}
+
+func (e *RedirectError) Error() string {
+	if e.Loop {
+		return fmt.Sprintf("redirect loop at %s", e.URL)
+	}
+	return fmt.Sprintf("stopped after %d redirects", e.Max)
+}
+
+// DialContext returns a dial function that connects with dialer over network,
+// "tcp4" or "tcp6", whatever network the caller asks for. An empty network
+// leaves the choice to the caller.
+func DialContext(dialer *net.Dialer, network string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network == "" {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// configureProxy routes the transport through an explicit proxy. HTTP(S)
+// proxies are handled by the transport itself; SOCKS5 proxies replace the
+// dialer, which reaches the SOCKS5 proxy over network when it is set.
+func configureProxy(transport *http.Transport, dialer *net.Dialer, network, proxyStr string) error {
+	proxyURL, err := url.Parse(proxyStr)
+	if err != nil || proxyURL.Host == "" {
+		return fmt.Errorf("invalid proxy URL: %s", proxyStr)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https":
+		transport.Proxy = http.ProxyURL(proxyURL)
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		if network == "" {
+			network = "tcp"
+		}
+		socksDialer, err := proxy.SOCKS5(network, proxyURL.Host, auth, dialer)
+		if err != nil {
+			return fmt.Errorf("failed to set up SOCKS5 proxy: %v", err)
+		}
+		contextDialer, ok := socksDialer.(proxy.ContextDialer)
+		if !ok {
+			return fmt.Errorf("SOCKS5 dialer does not support contexts")
+		}
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return contextDialer.DialContext(ctx, network, addr)
+		}
+	default:
+		return fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+	}
+
+	return nil
+}
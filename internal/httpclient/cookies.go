@@ -0,0 +1,254 @@
+package httpclient
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const httpOnlyPrefix = "#HttpOnly_"
+
+// CookieJar is an http.CookieJar that remembers every cookie it stores so the
+// jar can be written back out in Netscape cookies.txt format.
+type CookieJar struct {
+	jar     *cookiejar.Jar
+	mutex   sync.Mutex
+	entries map[string]cookieEntry // domain + path + name -> cookie
+}
+
+type cookieEntry struct {
+	domain     string
+	subdomains bool
+	path       string
+	secure     bool
+	httpOnly   bool
+	expires    time.Time // Zero for session cookies
+	name       string
+	value      string
+}
+
+// NewCookieJar creates an empty cookie jar
+func NewCookieJar() (*CookieJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	return &CookieJar{
+		jar:     jar,
+		entries: make(map[string]cookieEntry),
+	}, nil
+}
+
+// Cookies implements http.CookieJar
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// SetCookies implements http.CookieJar
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := time.Now()
+	for _, cookie := range cookies {
+		// Only remember cookies the jar accepted, so a saved file can't
+		// carry one host's cookie to another
+		domain, subdomains, ok := cookieDomain(u.Hostname(), cookie.Domain)
+		if !ok {
+			continue
+		}
+		entry := cookieEntry{
+			domain:     domain,
+			subdomains: subdomains,
+			path:       cookie.Path,
+			secure:     cookie.Secure,
+			httpOnly:   cookie.HttpOnly,
+			name:       cookie.Name,
+			value:      cookie.Value,
+		}
+		if entry.path == "" || !strings.HasPrefix(entry.path, "/") {
+			entry.path = defaultCookiePath(u.Path)
+		}
+
+		switch {
+		case cookie.MaxAge < 0:
+			entry.expires = now
+		case cookie.MaxAge > 0:
+			entry.expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		case !cookie.Expires.IsZero():
+			entry.expires = cookie.Expires
+		}
+
+		key := entry.domain + ";" + entry.path + ";" + entry.name
+		if !entry.expires.IsZero() && !entry.expires.After(now) {
+			delete(j.entries, key)
+			continue
+		}
+		j.entries[key] = entry
+	}
+}
+
+// Load reads cookies from a Netscape cookies.txt file into the jar
+func (j *CookieJar) Load(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := false
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			httpOnly = true
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		} else if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("%s:%d: expected 7 tab-separated fields, got %d", filename, lineNumber, len(fields))
+		}
+
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid expiry %q", filename, lineNumber, fields[4])
+		}
+
+		domain := fields[0]
+		secure := strings.EqualFold(fields[3], "TRUE")
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = domain
+		}
+		if expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		u := &url.URL{Scheme: scheme, Host: strings.TrimPrefix(domain, "."), Path: cookie.Path}
+		j.SetCookies(u, []*http.Cookie{cookie})
+	}
+
+	return scanner.Err()
+}
+
+// Save writes all unexpired cookies to a Netscape cookies.txt file. Session
+// cookies, which have no expiry time, are only written when keepSession is set.
+func (j *CookieJar) Save(filename string, keepSession bool) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "# Netscape HTTP Cookie File\n# Generated by wget. Edit at your own risk.\n\n")
+
+	keys := make([]string, 0, len(j.entries))
+	for key := range j.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	now := time.Now()
+	for _, key := range keys {
+		entry := j.entries[key]
+		if !entry.expires.IsZero() && !entry.expires.After(now) {
+			continue
+		}
+		if entry.expires.IsZero() && !keepSession {
+			continue
+		}
+
+		domain := entry.domain
+		if entry.httpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expiry int64
+		if !entry.expires.IsZero() {
+			expiry = entry.expires.Unix()
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(entry.subdomains), entry.path, netscapeBool(entry.secure),
+			expiry, entry.name, entry.value)
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// cookieDomain returns the domain a cookie from host is stored under and
+// whether it also applies to subdomains, following the checks cookiejar makes
+// (RFC 6265 5.3). Cookies whose Domain attribute doesn't cover host, or names a
+// public suffix such as "com", are rejected.
+func cookieDomain(host, domainAttr string) (domain string, subdomains, ok bool) {
+	host = strings.ToLower(host)
+	domain = strings.ToLower(strings.TrimPrefix(domainAttr, "."))
+	if domain == "" || domain == host && net.ParseIP(host) != nil {
+		return host, false, true
+	}
+	if net.ParseIP(host) != nil {
+		return "", false, false
+	}
+	if suffix, _ := publicsuffix.PublicSuffix(domain); suffix == domain {
+		// A public suffix may only set a host-only cookie for itself
+		return host, false, host == domain
+	}
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", false, false
+	}
+	return "." + domain, true, true
+}
+
+// defaultCookiePath computes the default cookie path from a request path (RFC 6265 5.1.4)
+func defaultCookiePath(requestPath string) string {
+	if requestPath == "" || requestPath[0] != '/' {
+		return "/"
+	}
+	dir := path.Dir(requestPath)
+	if dir == "." {
+		return "/"
+	}
+	return dir
+}
+
+func netscapeBool(value bool) string {
+	if value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
@@ -0,0 +1,54 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// IdleReader cancels a request when no data has been read for the configured
+// timeout. The request must have been created with the context whose cancel
+// function is passed to NewIdleReader.
+type IdleReader struct {
+	reader   io.Reader
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut atomic.Bool
+}
+
+// NewIdleReader wraps reader so that cancel is called after timeout elapses
+// without a successful read. A zero timeout disables the check.
+func NewIdleReader(reader io.Reader, timeout time.Duration, cancel context.CancelFunc) *IdleReader {
+	ir := &IdleReader{
+		reader:  reader,
+		timeout: timeout,
+	}
+	if timeout > 0 {
+		ir.timer = time.AfterFunc(timeout, func() {
+			ir.timedOut.Store(true)
+			cancel()
+		})
+	}
+	return ir
+}
+
+// Read implements io.Reader, restarting the idle timer on every call
+func (ir *IdleReader) Read(p []byte) (int, error) {
+	n, err := ir.reader.Read(p)
+	if ir.timer != nil {
+		ir.timer.Reset(ir.timeout)
+	}
+	if err != nil && err != io.EOF && ir.timedOut.Load() {
+		return n, fmt.Errorf("read timed out after %s", ir.timeout)
+	}
+	return n, err
+}
+
+// Stop releases the idle timer
+func (ir *IdleReader) Stop() {
+	if ir.timer != nil {
+		ir.timer.Stop()
+	}
+}
@@ -0,0 +1,180 @@
+package httpclient
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Netrc holds the logins of a .netrc file, looked up by host name
+type Netrc struct {
+	machines map[string]netrcLogin
+	fallback *netrcLogin // The "default" entry, used for hosts not listed
+}
+
+type netrcLogin struct {
+	user     string
+	password string
+}
+
+// DefaultNetrcPath returns $NETRC, or .netrc in the home directory
+func DefaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".netrc"
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// LoadNetrc reads a .netrc file. Its machine, default, login and password
+// entries are used; account entries and macro definitions are skipped.
+func LoadNetrc(path string) (*Netrc, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseNetrc(string(data))
+}
+
+func parseNetrc(content string) (*Netrc, error) {
+	n := &Netrc{machines: make(map[string]netrcLogin)}
+
+	var current *netrcLogin
+	var host string
+	save := func() {
+		if current == nil {
+			return
+		}
+		if host == "" {
+			n.fallback = current
+		} else if _, ok := n.machines[host]; !ok {
+			// The first entry for a host wins, as in other netrc readers
+			n.machines[host] = *current
+		}
+	}
+
+	inMacro := false
+	for lineNum, line := range strings.Split(content, "\n") {
+		// A macro definition runs until the next empty line
+		if inMacro {
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		tokens, err := netrcTokens(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum+1, err)
+		}
+		for i := 0; i < len(tokens); i++ {
+			value := func() (string, error) {
+				if i+1 >= len(tokens) {
+					return "", fmt.Errorf("line %d: missing value after %q", lineNum+1, tokens[i])
+				}
+				i++
+				return tokens[i], nil
+			}
+
+			switch tokens[i] {
+			case "machine":
+				save()
+				name, err := value()
+				if err != nil {
+					return nil, err
+				}
+				host, current = strings.ToLower(name), &netrcLogin{}
+			case "default":
+				save()
+				host, current = "", &netrcLogin{}
+			case "login", "password", "account":
+				field := tokens[i]
+				v, err := value()
+				if err != nil {
+					return nil, err
+				}
+				if current == nil {
+					return nil, fmt.Errorf("line %d: %q outside a machine entry", lineNum+1, field)
+				}
+				switch field {
+				case "login":
+					current.user = v
+				case "password":
+					current.password = v
+				}
+			case "macdef":
+				if _, err := value(); err != nil {
+					return nil, err
+				}
+				// The macro body starts on the next line
+				inMacro = true
+				i = len(tokens)
+			default:
+				return nil, fmt.Errorf("line %d: unexpected %q", lineNum+1, tokens[i])
+			}
+		}
+	}
+	save()
+	return n, nil
+}
+
+// netrcTokens splits a line on white space. Double-quoted tokens may hold
+// spaces, with backslash escaping the next character.
+func netrcTokens(line string) ([]string, error) {
+	var tokens []string
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if line == "" {
+			return tokens, nil
+		}
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t\r")
+			if end < 0 {
+				end = len(line)
+			}
+			tokens = append(tokens, line[:end])
+			line = line[end:]
+			continue
+		}
+
+		var token strings.Builder
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			token.WriteByte(line[i])
+		}
+		if i >= len(line) {
+			return nil, fmt.Errorf("unterminated quoted string")
+		}
+		tokens = append(tokens, token.String())
+		line = line[i+1:]
+	}
+}
+
+// Credentials returns the login for host, falling back to the default entry.
+// A nil Netrc has no logins.
+func (n *Netrc) Credentials(host string) (user, password string, ok bool) {
+	if n == nil {
+		return "", "", false
+	}
+	login, found := n.machines[strings.ToLower(host)]
+	if !found {
+		if n.fallback == nil {
+			return "", "", false
+		}
+		login = *n.fallback
+	}
+	if login.user == "" {
+		return "", "", false
+	}
+	return login.user, login.password, true
+}
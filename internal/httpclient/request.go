@@ -0,0 +1,74 @@
+package httpclient
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DefaultUserAgent is sent when no User-Agent is configured
+const DefaultUserAgent = "wget/1.0 (go)"
+
+// MissingScheme reports whether urlStr was given without a scheme, as in
+// "example.com/file" or "localhost:8080/file". Such URLs are tried over
+// https:// first and fall back to http:// when the server can't be reached.
+// A scheme is a name followed by a colon before the first "/", "?" or "#";
+// a colon followed only by digits is a port instead.
+func MissingScheme(urlStr string) bool {
+	prefix := urlStr
+	if end := strings.IndexAny(urlStr, "/?#"); end >= 0 {
+		prefix = urlStr[:end]
+	}
+	colon := strings.IndexByte(prefix, ':')
+	if colon < 0 || !validScheme(prefix[:colon]) {
+		return true
+	}
+	port := prefix[colon+1:]
+	return port != "" && strings.Trim(port, "0123456789") == ""
+}
+
+// validScheme reports whether s has the syntax of a URL scheme: a letter
+// followed by letters, digits, "+", "-" or "."
+func validScheme(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SetUserAgent sets the User-Agent header, falling back to DefaultUserAgent
+func SetUserAgent(req *http.Request, userAgent string) {
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+}
+
+// SetHeaders applies user-supplied headers, replacing any existing values
+func SetHeaders(req *http.Request, headers http.Header) {
+	for name, values := range headers {
+		req.Header.Del(name)
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+}
+
+// SetBasicAuth adds HTTP Basic credentials when a user name is configured,
+// otherwise when netrc has a login for the request's host
+func SetBasicAuth(req *http.Request, user, password string, netrc *Netrc) {
+	if user != "" {
+		req.SetBasicAuth(user, password)
+		return
+	}
+	if user, password, ok := netrc.Credentials(req.URL.Hostname()); ok {
+		req.SetBasicAuth(user, password)
+	}
+}
@@ -0,0 +1,36 @@
+package httpclient
+
+import "testing"
+
+func TestMissingScheme(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://example.com/file", false},
+		{"https://example.com", false},
+		{"HTTPS://example.com", false},
+		{"ftp://example.com/pub/file", false},
+		{"file:///tmp/file", false},
+		{"mailto:user@example.com", false},
+		{"svn+ssh://example.com/repo", false},
+		{"example.com", true},
+		{"example.com/file", true},
+		{"example.com/?next=http://other.example", true},
+		{"example.com?next=http://other.example", true},
+		{"example.com#http://other.example", true},
+		{"localhost:8080", true},
+		{"localhost:8080/path", true},
+		{"example.com:443/?q=a:b", true},
+		{"[::1]:8080/path", true},
+		{"1http:/x", true},
+		{":8080/path", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		if got := MissingScheme(tt.url); got != tt.want {
+			t.Errorf("MissingScheme(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
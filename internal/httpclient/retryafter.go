@@ -0,0 +1,47 @@
+package httpclient
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRetryAfter is the longest wait RetryAfter returns, so a server can't
+// stall a download for hours with a large Retry-After
+const MaxRetryAfter = 5 * time.Minute
+
+// RetryAfter returns how long a 429 or 503 response asks the client to wait
+// before trying again. The Retry-After header may hold either a number of
+// seconds or an HTTP date; a date in the past means no wait and long waits
+// are capped at MaxRetryAfter. It reports false for other statuses and for a
+// missing or malformed header.
+func RetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		if seconds > int(MaxRetryAfter/time.Second) {
+			return MaxRetryAfter, true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		} else if wait > MaxRetryAfter {
+			wait = MaxRetryAfter
+		}
+		return wait, true
+	}
+	return 0, false
+}
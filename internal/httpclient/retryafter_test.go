@@ -0,0 +1,53 @@
+package httpclient
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"seconds", http.StatusTooManyRequests, "120", 2 * time.Minute, true},
+		{"zero seconds", http.StatusServiceUnavailable, "0", 0, true},
+		{"seconds at the cap", http.StatusTooManyRequests, "300", MaxRetryAfter, true},
+		{"seconds over the cap", http.StatusTooManyRequests, "86400", MaxRetryAfter, true},
+		{"seconds overflowing a duration", http.StatusTooManyRequests, "99999999999999", MaxRetryAfter, true},
+		{"negative seconds", http.StatusTooManyRequests, "-5", 0, false},
+		{"date in the past", http.StatusServiceUnavailable, "Wed, 21 Oct 2015 07:28:00 GMT", 0, true},
+		{"date over the cap", http.StatusServiceUnavailable,
+			time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat), MaxRetryAfter, true},
+		{"malformed", http.StatusTooManyRequests, "soon", 0, false},
+		{"missing", http.StatusTooManyRequests, "", 0, false},
+		{"other status", http.StatusInternalServerError, "10", 0, false},
+	}
+
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: make(http.Header)}
+		if tt.value != "" {
+			resp.Header.Set("Retry-After", tt.value)
+		}
+		got, ok := RetryAfter(resp)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: RetryAfter(%q) = %v, %v, want %v, %v", tt.name, tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// TestRetryAfterDate checks a near HTTP date is honoured rather than capped
+func TestRetryAfterDate(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: make(http.Header)}
+	resp.Header.Set("Retry-After", time.Now().Add(90*time.Second).UTC().Format(http.TimeFormat))
+
+	got, ok := RetryAfter(resp)
+	// HTTP dates have one second resolution
+	if !ok || got < 88*time.Second || got > 90*time.Second {
+		t.Errorf("RetryAfter = %v, %v, want about 90s", got, ok)
+	}
+}
@@ -0,0 +1,62 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// LoadClientCertificate reads the PEM certificate and private key presented
+// to servers that require mutual TLS. An empty keyFile reads the key from
+// certFile, for files that hold both.
+func LoadClientCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load client certificate %s: %v", certFile, err)
+	}
+	return cert, nil
+}
+
+// LoadCACertificates returns the system root CAs plus the PEM certificates in
+// caFile, so servers signed by a private CA are trusted too
+func LoadCACertificates(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// tlsConfig builds the TLS settings of a transport from the certificate
+// options
+func tlsConfig(options *Options) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: options.NoCheckCertificate,
+	}
+	if options.Certificate != "" {
+		cert, err := LoadClientCertificate(options.Certificate, options.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if options.CACertificate != "" {
+		pool, err := LoadCACertificates(options.CACertificate)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
@@ -1,52 +1,104 @@
 package logging
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	TimeFormat = "2006-01-02 15:04:05"
-	LogFile    = "wget-log"
+	LogFile    = "wget-log" // Default log file of background downloads
 )
 
+// flushInterval is how often buffered log file output is written out, so a
+// crash loses at most this much of the log
+const flushInterval = time.Second
+
+// Logger writes download messages. It is safe for concurrent use; every
+// write holds mu so lines from parallel downloads don't interleave. Output to
+// a log file is buffered; call Close when done to write out the rest.
 type Logger struct {
-	output     io.Writer
-	background bool
+	mu            sync.Mutex
+	output        io.Writer
+	file          *os.File      // Log file behind output, nil when writing to stdout
+	buffer        *bufio.Writer // Buffers output to file
+	stopFlush     chan struct{} // Closed by Close to stop the periodic flush
+	background    bool
+	quiet         bool      // Only errors are written, to stderr
+	progressLine  bool      // A progress line is on screen without a trailing newline
+	progressStyle string    // ProgressBar or ProgressDot
+	dotBytes      int64     // Bytes of the current transfer already drawn as dots
+	lastDotBatch  time.Time // When batch progress was last printed in dot style
 }
 
-// NewLogger creates a new logger instance
-func NewLogger(background bool) *Logger {
+// NewLogger creates a new logger instance. Output is appended to logFile when
+// it is set; background downloads default to LogFile.
+func NewLogger(background bool, logFile string) *Logger {
 	logger := &Logger{
 		background: background,
 		output:     os.Stdout,
 	}
 
-	if background {
-		// Create or open log file for background downloads
-		file, err := os.OpenFile(LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if logFile == "" && background {
+		logFile = LogFile
+	}
+	if logFile != "" {
+		// Create or open the log file
+		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating log file: %v\n", err)
 			os.Exit(1)
 		}
-		logger.output = file
-
-		// Print message to stdout about log file
-		fmt.Printf("Output will be written to \"%s\".\n", LogFile)
+		logger.file = file
+		logger.buffer = bufio.NewWriter(file)
+		logger.output = logger.buffer
+		logger.stopFlush = make(chan struct{})
+		go logger.flushPeriodically()
 	}
+	logger.progressStyle = defaultProgressStyle(logger.output)
 
 	return logger
 }
 
+// NewDiscardLogger returns a logger that drops all output, for downloads whose
+// progress is reported elsewhere
+func NewDiscardLogger() *Logger {
+	return &Logger{
+		background:    true,
+		output:        io.Discard,
+		progressStyle: ProgressBar,
+	}
+}
+
+// endProgressLine moves past a progress line so the next message starts
+// on its own line. The caller must hold l.mu.
+func (l *Logger) endProgressLine() {
+	if l.progressLine {
+		fmt.Fprintln(l.output)
+		l.progressLine = false
+	}
+}
+
 // Printf writes formatted output to the logger
 func (l *Logger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.endProgressLine()
 	fmt.Fprintf(l.output, format, args...)
 }
 
 // Println writes a line to the logger
 func (l *Logger) Println(args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.endProgressLine()
 	fmt.Fprintln(l.output, args...)
 }
 
@@ -65,8 +117,32 @@ func (l *Logger) LogStatus(status string) {
 	l.Printf("sending request, awaiting response... status %s\n", status)
 }
 
-// LogContentSize logs the content size information
+// LogServerResponse logs the status line and every header of a response,
+// indented and sorted by name, for --server-response
+func (l *Logger) LogServerResponse(resp *http.Response) {
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %s %s\n", resp.Proto, resp.Status)
+	for _, name := range names {
+		for _, value := range resp.Header[name] {
+			fmt.Fprintf(&b, "  %s: %s\n", name, value)
+		}
+	}
+	l.Printf("%s", b.String())
+}
+
+// LogContentSize logs the content size information. Sizes of zero or less
+// mean the server didn't report one.
 func (l *Logger) LogContentSize(size int64) {
+	if size <= 0 {
+		l.Printf("content size: unknown\n")
+		return
+	}
 	l.Printf("content size: %d [~%.2fMB]\n", size, float64(size)/1024/1024)
 }
 
@@ -75,22 +151,96 @@ func (l *Logger) LogSavingTo(filepath string) {
 	l.Printf("saving file to: %s\n", filepath)
 }
 
+// LogResuming logs that a partial download is being continued
+func (l *Logger) LogResuming(offset int64) {
+	l.Printf("resuming download from byte %d\n", offset)
+}
+
+// LogRetry logs a failed attempt that is about to be retried
+func (l *Logger) LogRetry(attempt, tries int, wait time.Duration, err error) {
+	if tries > 0 {
+		l.Printf("attempt %d/%d failed: %v, retrying in %s\n", attempt, tries, err, FormatDuration(wait))
+	} else {
+		l.Printf("attempt %d failed: %v, retrying in %s\n", attempt, err, FormatDuration(wait))
+	}
+}
+
+// LogNoClobber logs that an existing file is kept instead of downloading it again
+func (l *Logger) LogNoClobber(filepath string) {
+	l.Printf("File '%s' already there; not retrieving.\n", filepath)
+}
+
+// LogNotModified logs that the server copy is not newer than the local file
+func (l *Logger) LogNotModified(filepath string) {
+	l.Printf("Server file no newer than local file '%s'; not retrieving.\n", filepath)
+}
+
 // LogDownloaded logs successful download completion
 func (l *Logger) LogDownloaded(url string) {
-	l.Printf("Downloaded [%s]\n", url)
+	l.Printf("Downloaded [%s]\n", RedactURL(url))
+}
+
+// LogSummary logs how much a download received, how long it took and its
+// average speed
+func (l *Logger) LogSummary(bytes int64, elapsed time.Duration) {
+	l.Printf("Downloaded %s in %s (%s)\n", FormatBytes(bytes), formatElapsed(elapsed), FormatSpeed(averageSpeed(bytes, elapsed)))
 }
 
-// LogError logs an error message
+// LogBatchSummary logs the grand total of a batch of downloads
+func (l *Logger) LogBatchSummary(files int, bytes int64, elapsed time.Duration) {
+	l.Printf("Downloaded %d files, %s in %s (%s)\n", files, FormatBytes(bytes), formatElapsed(elapsed), FormatSpeed(averageSpeed(bytes, elapsed)))
+}
+
+// averageSpeed returns bytes per second over elapsed
+func averageSpeed(bytes int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes) / elapsed.Seconds()
+}
+
+// formatElapsed formats the duration of a finished transfer, keeping
+// fractions of a second for short ones
+func formatElapsed(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	}
+	return FormatDuration(d)
+}
+
+// LogError logs an error message. Errors still reach stderr in quiet mode.
 func (l *Logger) LogError(err error) {
+	if l.quiet {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
 	l.Printf("Error: %v\n", err)
 }
 
+// SetQuiet silences all normal output, leaving only errors
+func (l *Logger) SetQuiet() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.quiet = true
+	l.output = io.Discard
+}
+
 // LogProgress logs download progress (for progress bar updates)
 func (l *Logger) LogProgress(downloaded, total int64, speed float64, eta time.Duration) {
-	if l.background {
+	if l.background || l.quiet {
 		// Don't show progress bar in background mode
 		return
 	}
+	if total <= 0 {
+		return // No percentage without a total, see LogUnknownProgress
+	}
+
+	if l.progressStyle == ProgressDot {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.logDots(downloaded, total, speed, eta)
+		return
+	}
 
 	downloadedStr := FormatBytes(downloaded)
 	totalStr := FormatBytes(total)
@@ -98,37 +248,121 @@ func (l *Logger) LogProgress(downloaded, total int64, speed float64, eta time.Du
 
 	percentage := float64(downloaded) / float64(total) * 100
 
-	// Create progress bar
-	barWidth := 80
-	filled := int(percentage / 100 * float64(barWidth))
+	bar := progressBar(percentage, 80)
+
+	etaStr := FormatDuration(eta)
+
+	// Print progress line (overwrite previous line)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.output, "\r %s / %s [%s] %.2f%% %s %s",
+		downloadedStr, totalStr, bar, percentage, speedStr, etaStr)
+}
+
+// spinnerFrames are cycled through while a download of unknown size runs
+const spinnerFrames = `|/-\`
+
+// LogUnknownProgress logs the progress of a download whose total size is
+// unknown: a spinner, the bytes received so far and the current speed
+func (l *Logger) LogUnknownProgress(downloaded int64, frame int, speed float64) {
+	if l.background {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.progressStyle == ProgressDot {
+		l.logDots(downloaded, -1, speed, 0)
+		return
+	}
+	fmt.Fprintf(l.output, "\r [%c] %s %s", spinnerFrames[frame%len(spinnerFrames)],
+		FormatBytes(downloaded), FormatSpeed(speed))
+}
+
+// LogTransferTotal logs the final size and average speed of a download whose
+// total size was unknown, replacing its progress line
+func (l *Logger) LogTransferTotal(downloaded int64, speed float64) {
+	prefix := ""
+	if !l.background && l.progressStyle == ProgressBar {
+		prefix = "\r"
+	}
+	l.mu.Lock()
+	l.dotBytes = 0
+	l.mu.Unlock()
+	l.Printf("%s [done] %s received, average speed %s\n", prefix, FormatBytes(downloaded), FormatSpeed(speed))
+}
+
+// LogBatchProgress draws a single progress line for a batch of downloads:
+// aggregate bytes against the known total, completed files, speed and ETA
+func (l *Logger) LogBatchProgress(downloaded, total int64, completed, files int, speed float64, eta time.Duration) {
+	if l.background {
+		return
+	}
+
+	line := fmt.Sprintf(" %s", FormatBytes(downloaded))
+	if total > 0 {
+		percentage := float64(downloaded) / float64(total) * 100
+		if percentage > 100 {
+			percentage = 100
+		}
+		line = fmt.Sprintf(" %s / %s [%s] %.2f%%", FormatBytes(downloaded), FormatBytes(total),
+			progressBar(percentage, 40), percentage)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.progressStyle == ProgressDot {
+		// A full line now and then instead of redrawing in place
+		if time.Since(l.lastDotBatch) < dotBatchEvery {
+			return
+		}
+		l.lastDotBatch = time.Now()
+		l.endProgressLine()
+		fmt.Fprintf(l.output, "%s  %d/%d files %s %s\n", line, completed, files, FormatSpeed(speed), FormatDuration(eta))
+		return
+	}
+	fmt.Fprintf(l.output, "\r%s  %d/%d files %s %s", line, completed, files, FormatSpeed(speed), FormatDuration(eta))
+	l.progressLine = true
+}
+
+// LogCrawlProgress logs a progress line for a mirror: files saved so far, the
+// URLs left at the current depth and those queued for the next one. It's a
+// full line rather than a redrawn one, so it also suits background logs.
+func (l *Logger) LogCrawlProgress(files, remaining, queued, depth int) {
+	if l.quiet {
+		return
+	}
+	l.Printf("Progress: %d files downloaded, depth %d, %d URLs left at this depth, %d queued for the next\n",
+		files, depth, remaining, queued)
+}
+
+// progressBar renders a bar of the given width filled to percentage
+func progressBar(percentage float64, width int) string {
+	filled := int(percentage / 100 * float64(width))
 	bar := ""
-	for i := 0; i < barWidth; i++ {
+	for i := 0; i < width; i++ {
 		if i < filled {
 			bar += "="
 		} else {
 			bar += " "
 		}
 	}
-
-	etaStr := FormatDuration(eta)
-
-	// Print progress line (overwrite previous line)
-	fmt.Printf("\r %s / %s [%s] %.2f%% %s %s",
-		downloadedStr, totalStr, bar, percentage, speedStr, etaStr)
+	return bar
 }
 
 // FormatBytes formats bytes into human-readable format
 func FormatBytes(bytes int64) string {
 	const unit = 1024
+	const prefixes = "KMGTPE"
 	if bytes < unit {
 		return fmt.Sprintf("%d B", bytes)
 	}
 	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
+	for n := bytes / unit; n >= unit && exp < len(prefixes)-1; n /= unit {
 		div *= unit
 		exp++
 	}
-	return fmt.Sprintf("%.2f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+	return fmt.Sprintf("%.2f %ciB", float64(bytes)/float64(div), prefixes[exp])
 }
 
 // FormatSpeed formats speed into human-readable format
@@ -138,7 +372,7 @@ func FormatSpeed(bytesPerSecond float64) string {
 		return fmt.Sprintf("%.2f B/s", bytesPerSecond)
 	}
 
-	units := []string{"KiB/s", "MiB/s", "GiB/s"}
+	units := []string{"KiB/s", "MiB/s", "GiB/s", "TiB/s", "PiB/s"}
 	div := float64(unit)
 	for i, u := range units {
 		if bytesPerSecond < div*unit || i == len(units)-1 {
@@ -149,32 +383,84 @@ func FormatSpeed(bytesPerSecond float64) string {
 	return fmt.Sprintf("%.2f B/s", bytesPerSecond)
 }
 
-// FormatDuration formats duration for ETA display
+// FormatDuration formats duration for ETA display, e.g. "45s", "5m", "2h3m4s"
+// or "3d1h5m". Seconds are left out once the duration reaches a day, and zero
+// units at the end are dropped.
 func FormatDuration(d time.Duration) string {
 	if d < 0 {
 		return "0s"
 	}
 
-	seconds := int(d.Seconds())
+	seconds := int64(d.Seconds())
 	if seconds < 60 {
 		return fmt.Sprintf("%ds", seconds)
 	}
 
-	minutes := seconds / 60
-	seconds = seconds % 60
-	if minutes < 60 {
-		return fmt.Sprintf("%dm%ds", minutes, seconds)
+	units := []struct {
+		value  int64
+		suffix string
+	}{
+		{seconds / 86400, "d"},
+		{seconds / 3600 % 24, "h"},
+		{seconds / 60 % 60, "m"},
+		{seconds % 60, "s"},
+	}
+	if units[0].value > 0 {
+		units = units[:3]
+	}
+
+	// Start at the largest non-zero unit and stop after the last one
+	first, last := 0, len(units)-1
+	for units[first].value == 0 {
+		first++
+	}
+	for units[last].value == 0 {
+		last--
 	}
 
-	hours := minutes / 60
-	minutes = minutes % 60
-	return fmt.Sprintf("%dh%dm%ds", hours, minutes, seconds)
+	var b strings.Builder
+	for _, unit := range units[first : last+1] {
+		fmt.Fprintf(&b, "%d%s", unit.value, unit.suffix)
+	}
+	return b.String()
 }
 
 // Close closes the logger (important for file-based loggers)
 func (l *Logger) Close() error {
-	if file, ok := l.output.(*os.File); ok && file != os.Stdout && file != os.Stderr {
-		return file.Close()
+	if l.file == nil {
+		return nil
+	}
+	close(l.stopFlush)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	flushErr := l.buffer.Flush()
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// Flush writes out buffered log file output
+func (l *Logger) Flush() error {
+	if l.buffer == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buffer.Flush()
+}
+
+// flushPeriodically flushes the log file every flushInterval until Close
+func (l *Logger) flushPeriodically() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.Flush()
+		case <-l.stopFlush:
+			return
+		}
 	}
-	return nil
 }
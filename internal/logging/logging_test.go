@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestLoggerConcurrentWrites logs from several goroutines through one Logger,
+// as batch downloads do. Run with -race to check the locking.
+func TestLoggerConcurrentWrites(t *testing.T) {
+	var output bytes.Buffer
+	logger := &Logger{output: &output}
+
+	const workers, lines = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				logger.Printf("worker %d line %d\n", w, i)
+				logger.Println("worker", w, "line", i, "again")
+				logger.LogDownloaded(fmt.Sprintf("http://example.com/%d/%d", w, i))
+				logger.LogError(fmt.Errorf("worker %d error %d", w, i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	patterns := map[string]*regexp.Regexp{
+		"Printf":        regexp.MustCompile(`^worker \d+ line \d+$`),
+		"Println":       regexp.MustCompile(`^worker \d+ line \d+ again$`),
+		"LogDownloaded": regexp.MustCompile(`^Downloaded \[http://example\.com/\d+/\d+\]$`),
+		"LogError":      regexp.MustCompile(`^Error: worker \d+ error \d+$`),
+	}
+	counts := make(map[string]int)
+	for _, line := range strings.Split(output.String(), "\n") {
+		for name, pattern := range patterns {
+			if pattern.MatchString(line) {
+				counts[name]++
+			}
+		}
+	}
+	for name := range patterns {
+		if counts[name] != workers*lines {
+			t.Errorf("%s: got %d intact lines, want %d", name, counts[name], workers*lines)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{0, "0 B"},
+		{512, "512 B"},
+		{1023, "1023 B"},
+		{1024, "1.00 KiB"},
+		{1536, "1.50 KiB"},
+		{1<<20 - 1, "1024.00 KiB"},
+		{1 << 20, "1.00 MiB"},
+		{3000000, "2.86 MiB"},
+		{1 << 30, "1.00 GiB"},
+		{1 << 40, "1.00 TiB"},
+		{1 << 50, "1.00 PiB"},
+		{1 << 60, "1.00 EiB"},
+		{math.MaxInt64, "8.00 EiB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatBytes(tt.bytes); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.bytes, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSpeed(t *testing.T) {
+	tests := []struct {
+		bytesPerSecond float64
+		want           string
+	}{
+		{0, "0.00 B/s"},
+		{11.19, "11.19 B/s"},
+		{1023, "1023.00 B/s"},
+		{1024, "1.00 KiB/s"},
+		{1 << 20, "1.00 MiB/s"},
+		{1.02 * (1 << 20), "1.02 MiB/s"},
+		{1 << 30, "1.00 GiB/s"},
+		{1 << 40, "1.00 TiB/s"},
+		{1 << 50, "1.00 PiB/s"},
+		{1 << 60, "1024.00 PiB/s"},
+		{math.MaxInt64, "8192.00 PiB/s"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatSpeed(tt.bytesPerSecond); got != tt.want {
+			t.Errorf("FormatSpeed(%v) = %q, want %q", tt.bytesPerSecond, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{-time.Second, "0s"},
+		{0, "0s"},
+		{1500 * time.Millisecond, "1s"},
+		{59 * time.Second, "59s"},
+		{60 * time.Second, "1m"},
+		{61 * time.Second, "1m1s"},
+		{5 * time.Minute, "5m"},
+		{59*time.Minute + 59*time.Second, "59m59s"},
+		{time.Hour, "1h"},
+		{time.Hour + 5*time.Second, "1h0m5s"},
+		{2*time.Hour + 3*time.Minute + 4*time.Second, "2h3m4s"},
+		{2*time.Hour + 30*time.Minute, "2h30m"},
+		{23*time.Hour + 59*time.Minute + 59*time.Second, "23h59m59s"},
+		{24 * time.Hour, "1d"},
+		{24*time.Hour + 59*time.Second, "1d"},
+		{24*time.Hour + 5*time.Minute, "1d0h5m"},
+		{73*time.Hour + 5*time.Minute + 2*time.Second, "3d1h5m"},
+		{49 * time.Hour, "2d1h"},
+		{400 * 24 * time.Hour, "400d"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatDuration(tt.d); got != tt.want {
+			t.Errorf("FormatDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Progress styles, see Logger.SetProgressStyle
+const (
+	ProgressBar = "bar" // A single line redrawn in place, for terminals
+	ProgressDot = "dot" // Rows of dots ending in newlines, for logs and pipes
+)
+
+// Dot style layout: every dot stands for dotSize bytes, dots are grouped in
+// eights and a row covers dotsPerLine of them, 384KiB in total
+const (
+	dotSize       = 8 << 10
+	dotsPerGroup  = 8
+	dotsPerLine   = 48
+	dotLineSize   = dotSize * dotsPerLine
+	dotBatchEvery = 5 * time.Second // How often batch progress is printed in dot style
+)
+
+// defaultProgressStyle draws a bar only when output goes to a terminal, so
+// redirected output and log files aren't flooded with carriage returns
+func defaultProgressStyle(output io.Writer) string {
+	if file, ok := output.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		return ProgressBar
+	}
+	return ProgressDot
+}
+
+// SetProgressStyle overrides the progress style picked from the terminal
+func (l *Logger) SetProgressStyle(style string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.progressStyle = style
+}
+
+// EndProgress moves past the progress display of a finished transfer
+func (l *Logger) EndProgress() {
+	if l.background {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.progressStyle == ProgressDot {
+		l.endProgressLine()
+		l.dotBytes = 0
+		return
+	}
+	fmt.Fprintln(l.output)
+}
+
+// logDots draws a dot for every dotSize bytes received since the last call,
+// ending each full row with the percentage (when total is known), speed and
+// ETA. The caller must hold l.mu.
+func (l *Logger) logDots(downloaded, total int64, speed float64, eta time.Duration) {
+	// A smaller count means a new transfer has started
+	if downloaded < l.dotBytes {
+		l.endProgressLine()
+		l.dotBytes = 0
+	}
+
+	var b strings.Builder
+	for l.dotBytes+dotSize <= downloaded {
+		switch {
+		case l.dotBytes%dotLineSize == 0:
+			fmt.Fprintf(&b, "%7dK ", l.dotBytes>>10)
+		case l.dotBytes%(dotSize*dotsPerGroup) == 0:
+			b.WriteByte(' ')
+		}
+		b.WriteByte('.')
+		l.dotBytes += dotSize
+
+		if l.dotBytes%dotLineSize == 0 {
+			if total > 0 {
+				fmt.Fprintf(&b, " %3d%%", l.dotBytes*100/total)
+			}
+			fmt.Fprintf(&b, " %s", FormatSpeed(speed))
+			if total > 0 {
+				fmt.Fprintf(&b, " %s", FormatDuration(eta))
+			}
+			b.WriteByte('\n')
+		}
+	}
+
+	if b.Len() == 0 {
+		return
+	}
+	fmt.Fprint(l.output, b.String())
+	l.progressLine = l.dotBytes%dotLineSize != 0
+}
@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// redacted replaces secrets in logged URLs, matching url.URL.Redacted
+const redacted = "xxxxx"
+
+// sensitiveParams are query parameters whose values are masked in logged URLs
+var sensitiveParams = map[string]bool{
+	"token":                true,
+	"access_token":         true,
+	"refresh_token":        true,
+	"id_token":             true,
+	"api_key":              true,
+	"apikey":               true,
+	"key":                  true,
+	"password":             true,
+	"passwd":               true,
+	"secret":               true,
+	"client_secret":        true,
+	"signature":            true,
+	"sig":                  true,
+	"auth":                 true,
+	"x-amz-credential":     true,
+	"x-amz-signature":      true,
+	"x-amz-security-token": true,
+}
+
+// RedactURL masks the password of a URL, or its user name when there is no
+// password, and the values of query parameters that commonly carry secrets,
+// such as token=, so the URL can be logged. URLs without anything to mask are
+// returned unchanged.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	changed := false
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+			changed = true
+		} else if u.User.Username() != "" {
+			// A user name without a password is often an access token
+			u.User = url.User(redacted)
+			changed = true
+		}
+	}
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, param := range params {
+			name, _, found := strings.Cut(param, "=")
+			decoded, err := url.QueryUnescape(name)
+			if err != nil {
+				decoded = name
+			}
+			if found && sensitiveParams[strings.ToLower(decoded)] {
+				params[i] = name + "=" + redacted
+				changed = true
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+
+	if !changed {
+		return rawURL
+	}
+	return u.String()
+}
+
+// RedactError masks the URL inside a request error from an http.Client, which
+// only hides the password itself. err is returned for convenience.
+func RedactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = RedactURL(urlErr.URL)
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"wget/internal/httpclient"
+	"wget/internal/logging"
+)
+
+// authRecorder serves a page and remembers the Authorization header of every request
+type authRecorder struct {
+	mutex   sync.Mutex
+	headers map[string]string // Path -> Authorization
+	page    string
+}
+
+func (a *authRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mutex.Lock()
+	a.headers[r.URL.Path] = r.Header.Get("Authorization")
+	a.mutex.Unlock()
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, a.page)
+}
+
+// TestSpannedHostsDoNotReceiveCredentials mirrors a page linking to another
+// host and checks the explicit user and password only reach the start host,
+// while the other host still gets its own .netrc login
+func TestSpannedHostsDoNotReceiveCredentials(t *testing.T) {
+	other := &authRecorder{headers: make(map[string]string), page: "<html></html>"}
+	otherServer := httptest.NewServer(other)
+	defer otherServer.Close()
+	// Reach the other server as localhost so it differs from the start host
+	otherURL := strings.Replace(otherServer.URL, "127.0.0.1", "localhost", 1)
+
+	start := &authRecorder{headers: make(map[string]string),
+		page: fmt.Sprintf(`<a href="%s/page.html">x</a>`, otherURL)}
+	startServer := httptest.NewServer(start)
+	defer startServer.Close()
+
+	for _, tt := range []struct {
+		name      string
+		netrc     string // .netrc content, none when empty
+		wantOther string
+	}{
+		{"explicit only", "", ""},
+		{"netrc for spanned host", "machine localhost login guest password secret\n", basicAuth("guest", "secret")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var netrc *httpclient.Netrc
+			if tt.netrc != "" {
+				netrcPath := filepath.Join(t.TempDir(), "netrc")
+				if err := os.WriteFile(netrcPath, []byte(tt.netrc), 0600); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				if netrc, err = httpclient.LoadNetrc(netrcPath); err != nil {
+					t.Fatal(err)
+				}
+			}
+			other.headers = make(map[string]string)
+			start.headers = make(map[string]string)
+
+			options := &Options{
+				OutputPath: t.TempDir(),
+				SpanHosts:  true,
+				User:       "alice",
+				Password:   "hunter2",
+				Netrc:      netrc,
+			}
+			if err := MirrorWebsite(context.Background(), startServer.URL+"/", options, logging.NewDiscardLogger()); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := start.headers["/"], basicAuth("alice", "hunter2"); got != want {
+				t.Errorf("start host Authorization = %q, want %q", got, want)
+			}
+			got, ok := other.headers["/page.html"]
+			if !ok {
+				t.Fatal("spanned host was not requested")
+			}
+			if got != tt.wantOther {
+				t.Errorf("spanned host Authorization = %q, want %q", got, tt.wantOther)
+			}
+		})
+	}
+}
+
+func basicAuth(user, password string) string {
+	req := &http.Request{Header: make(http.Header)}
+	req.SetBasicAuth(user, password)
+	return req.Header.Get("Authorization")
+}
@@ -0,0 +1,178 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"wget/internal/downloader"
+	"wget/internal/logging"
+)
+
+// BrokenLinksFileName is the report of broken links written to the output
+// directory after a mirror run. The report starts with brokenLinksHeader, and
+// only a file starting with it is replaced or removed, so a broken-links.txt
+// served by the mirrored site itself is left alone.
+const BrokenLinksFileName = "broken-links.txt"
+
+// brokenLinksHeader marks a broken links report as written by the mirror
+const brokenLinksHeader = "# Broken links found while mirroring\n"
+
+// brokenLink is a URL that couldn't be fetched during the crawl
+type brokenLink struct {
+	URL      string `json:"url"`
+	Status   int    `json:"status"`   // HTTP status, 0 for network errors
+	Reason   string `json:"reason"`   // Status line or error message
+	Referrer string `json:"referrer"` // Page the URL was found on, empty for the start URL
+}
+
+// recordBroken notes a URL that returned a non-2xx status or couldn't be
+// fetched at all, along with the page that linked to it
+func (s *MirrorState) recordBroken(urlStr string, status int, reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.brokenLinks = append(s.brokenLinks, brokenLink{
+		URL:      urlStr,
+		Status:   status,
+		Reason:   reason,
+		Referrer: s.referrers[urlStr],
+	})
+}
+
+// recordReferrer remembers the first page a queued URL was found on. The
+// caller must hold s.mutex.
+func (s *MirrorState) recordReferrer(urlStr, pageURL string) {
+	if _, ok := s.referrers[urlStr]; !ok && urlStr != pageURL {
+		s.referrers[urlStr] = pageURL
+	}
+}
+
+// formatBrokenLinks lists the broken links grouped by status code, with
+// network errors last, each followed by the page that referenced it
+func (s *MirrorState) formatBrokenLinks() string {
+	groups := make(map[int][]brokenLink)
+	for _, link := range s.brokenLinks {
+		groups[link.Status] = append(groups[link.Status], link)
+	}
+	statuses := make([]int, 0, len(groups))
+	for status := range groups {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		// Network errors (status 0) sort after every HTTP status
+		if statuses[i] == 0 || statuses[j] == 0 {
+			return statuses[j] == 0 && statuses[i] != 0
+		}
+		return statuses[i] < statuses[j]
+	})
+
+	var b strings.Builder
+	for _, status := range statuses {
+		links := groups[status]
+		sort.Slice(links, func(i, j int) bool { return links[i].URL < links[j].URL })
+
+		if status == 0 {
+			fmt.Fprintf(&b, "Network errors (%d):\n", len(links))
+		} else {
+			fmt.Fprintf(&b, "%d %s (%d):\n", status, http.StatusText(status), len(links))
+		}
+		for _, link := range links {
+			if status == 0 {
+				fmt.Fprintf(&b, "  %s: %s\n", logging.RedactURL(link.URL), link.Reason)
+			} else {
+				fmt.Fprintf(&b, "  %s\n", logging.RedactURL(link.URL))
+			}
+			if link.Referrer != "" {
+				fmt.Fprintf(&b, "    referenced by %s\n", logging.RedactURL(link.Referrer))
+			} else {
+				fmt.Fprintf(&b, "    start URL\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// brokenLinksError returns the error of a spider run that found broken links,
+// wrapping the same typed error a single URL check returns so both exit with
+// the same code. A server error takes precedence over network errors.
+func (s *MirrorState) brokenLinksError() error {
+	if len(s.brokenLinks) == 0 {
+		return nil
+	}
+	first := s.brokenLinks[0]
+	for _, link := range s.brokenLinks {
+		if link.Status != 0 {
+			first = link
+			break
+		}
+	}
+
+	var err error
+	if first.Status != 0 {
+		err = &downloader.StatusError{Code: first.Status, Status: first.Reason}
+	} else {
+		err = &downloader.NetworkError{Op: "fetch " + logging.RedactURL(first.URL), Err: errors.New(first.Reason)}
+	}
+	return fmt.Errorf("found %d broken links: %w", len(s.brokenLinks), err)
+}
+
+// reportBrokenLinks logs the broken links summary. In spider mode it returns
+// an error when any link was broken; otherwise the report is also written to
+// BrokenLinksFileName, and a stale report from an earlier run is removed.
+func (s *MirrorState) reportBrokenLinks(options *Options) error {
+	if options.Spider {
+		s.logger.Printf("Spider mode: checked %d URLs, found %d broken links\n", s.checked, len(s.brokenLinks))
+	} else if len(s.brokenLinks) > 0 {
+		s.logger.Printf("Found %d broken links\n", len(s.brokenLinks))
+	}
+
+	var report string
+	if len(s.brokenLinks) > 0 {
+		report = s.formatBrokenLinks()
+		s.logger.Printf("%s", report)
+	}
+	if options.Spider {
+		return s.brokenLinksError()
+	}
+
+	path := filepath.Join(options.OutputPath, BrokenLinksFileName)
+	if _, err := os.Lstat(path); err == nil && !isBrokenLinksReport(path) {
+		if report != "" {
+			s.logger.Printf("Warning: not writing %s: the file exists and is not a broken links report\n", path)
+		}
+		return nil
+	}
+	if report == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			s.logger.Printf("Warning: failed to remove %s: %v\n", path, err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(brokenLinksHeader+report), 0644); err != nil {
+		s.logger.Printf("Warning: failed to write %s: %v\n", path, err)
+		return nil
+	}
+	s.logger.Printf("Broken links report saved to %s\n", path)
+	return nil
+}
+
+// isBrokenLinksReport reports whether the file at path is a report written by
+// reportBrokenLinks rather than a file saved from the mirrored site
+func isBrokenLinksReport(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, len(brokenLinksHeader))
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+	return string(header) == brokenLinksHeader
+}
@@ -3,70 +3,124 @@ package mirror
 import (
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"golang.org/x/net/html"
 )
 
-// ConvertLinks converts absolute URLs in content to relative paths for offline browsing
-func ConvertLinks(content string, baseURL *url.URL, outputDir string, currentFilePath string) string {
-	resources, err := ParseHTML(content, baseURL)
+// LocalPathFunc returns the local file a URL was saved as, or "" when it
+// wasn't saved
+type LocalPathFunc func(urlStr string) string
+
+// ConvertLinks converts the references in an HTML page to relative paths for
+// offline browsing. pageURL is the URL the page was downloaded from and
+// localPath maps each referenced URL to its local file. References to URLs
+// without a local file are made absolute so they still work online. Only the
+// exact span of each reference is rewritten, so the same URL appearing in
+// scripts or as part of a longer URL is left alone. A <base href> is removed,
+// since the converted paths are relative to the saved file.
+func ConvertLinks(content string, pageURL *url.URL, currentFilePath string, localPath LocalPathFunc) string {
+	resources, err := ParseHTML(content, pageURL)
 	if err != nil {
 		return content
 	}
 
-	convertedContent := content
+	return removeBaseHref(rewriteReferences(content, resources, currentFilePath, localPath))
+}
 
-	// Convert each resource URL to a relative path
-	for _, resource := range resources {
-		originalURL := resource.URL
-		relativePath := convertURLToRelativePath(originalURL, baseURL, outputDir, currentFilePath)
-		
-		if relativePath != "" {
-			// Replace the original URL with the relative path
-			convertedContent = strings.ReplaceAll(convertedContent, originalURL, relativePath)
+// removeBaseHref drops the <base> tags that set an href. Left in place, the
+// browser would resolve the converted relative paths against the original
+// site instead of the saved file.
+func removeBaseHref(content string) string {
+	var converted strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	last, tokenEnd := 0, 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		tokenStart := tokenEnd
+		tokenEnd += len(tokenizer.Raw())
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+		name, _ := tokenizer.TagName()
+		if string(name) != "base" {
+			continue
+		}
+		if _, ok := parseTag("base", content[tokenStart:tokenEnd], tokenStart).attrs["href"]; !ok {
+			continue
 		}
+		converted.WriteString(content[last:tokenStart])
+		last = tokenEnd
 	}
-
-	return convertedContent
+	if last == 0 {
+		return content
+	}
+	converted.WriteString(content[last:])
+	return converted.String()
 }
 
-// ConvertCSSLinks converts URLs in CSS content to relative paths
-func ConvertCSSLinks(content string, baseURL *url.URL, outputDir string, currentFilePath string) string {
-	resources, err := ParseCSS(content, baseURL)
+// ConvertCSSLinks converts the references in a stylesheet to relative paths
+func ConvertCSSLinks(content string, pageURL *url.URL, currentFilePath string, localPath LocalPathFunc) string {
+	resources, err := ParseCSS(content, pageURL)
 	if err != nil {
 		return content
 	}
 
-	convertedContent := content
+	return rewriteReferences(content, resources, currentFilePath, localPath)
+}
+
+// rewriteReferences replaces the Original text of each resource at its Offset
+// with the relative path to the local copy, or with its absolute URL when
+// there is no local copy
+func rewriteReferences(content string, resources []Resource, currentFilePath string, localPath LocalPathFunc) string {
+	sort.SliceStable(resources, func(i, j int) bool {
+		return resources[i].Offset < resources[j].Offset
+	})
 
-	// Convert each resource URL to a relative path
+	var converted strings.Builder
+	last := 0
 	for _, resource := range resources {
-		originalURL := resource.URL
-		relativePath := convertURLToRelativePath(originalURL, baseURL, outputDir, currentFilePath)
-		
-		if relativePath != "" {
-			// Replace the original URL with the relative path in CSS url() syntax
-			convertedContent = strings.ReplaceAll(convertedContent, originalURL, relativePath)
+		// Skip references that overlap one already rewritten
+		if resource.Offset < last {
+			continue
 		}
+
+		replacement := convertURLToRelativePath(resource.URL, currentFilePath, localPath)
+		if replacement == "" {
+			replacement = resource.URL
+		}
+		if replacement == resource.Original {
+			continue
+		}
+
+		converted.WriteString(content[last:resource.Offset])
+		converted.WriteString(replacement)
+		last = resource.Offset + len(resource.Original)
 	}
+	converted.WriteString(content[last:])
 
-	return convertedContent
+	return converted.String()
 }
 
-// convertURLToRelativePath converts an absolute URL to a relative file path
-func convertURLToRelativePath(urlStr string, baseURL *url.URL, outputDir string, currentFilePath string) string {
+// convertURLToRelativePath converts an absolute URL to the path of its local
+// file relative to currentFilePath, keeping any fragment
+func convertURLToRelativePath(urlStr string, currentFilePath string, localPathFor LocalPathFunc) string {
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
 		return ""
 	}
 
-	// Only convert URLs from the same domain
-	if parsedURL.Host != baseURL.Host {
+	fragment := parsedURL.Fragment
+	parsedURL.Fragment = ""
+	localPath := localPathFor(parsedURL.String())
+	if localPath == "" {
 		return ""
 	}
 
-	// Convert URL path to local file path
-	localPath := convertURLPathToLocalPath(parsedURL.Path, outputDir)
-	
 	// Calculate relative path from current file to target file
 	currentDir := filepath.Dir(currentFilePath)
 	relativePath, err := filepath.Rel(currentDir, localPath)
@@ -74,34 +128,43 @@ func convertURLToRelativePath(urlStr string, baseURL *url.URL, outputDir string,
 		return ""
 	}
 
-	// Convert backslashes to forward slashes for web compatibility
-	return strings.ReplaceAll(relativePath, "\\", "/")
-}
-
-// convertURLPathToLocalPath converts a URL path to a local file system path
-func convertURLPathToLocalPath(urlPath string, outputDir string) string {
-	// Remove leading slash
-	if strings.HasPrefix(urlPath, "/") {
-		urlPath = urlPath[1:]
+	// Convert backslashes to forward slashes for web compatibility and escape
+	// characters such as "%" or "?" that appear in saved file names
+	segments := strings.Split(strings.ReplaceAll(relativePath, "\\", "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
 	}
-
-	// If path is empty or ends with /, assume index.html
-	if urlPath == "" || strings.HasSuffix(urlPath, "/") {
-		urlPath = filepath.Join(urlPath, "index.html")
+	relativePath = strings.Join(segments, "/")
+	if fragment != "" {
+		relativePath += "#" + fragment
 	}
+	return relativePath
+}
 
-	// Convert URL path separators to OS-specific path separators
-	localPath := filepath.Join(outputDir, filepath.FromSlash(urlPath))
-	
-	return localPath
+// convertURLPathToLocalPath converts a URL path and query to a local file
+// system path inside outputDir, see localPathSegments
+func convertURLPathToLocalPath(urlPath string, rawQuery string, outputDir string) string {
+	segments := localPathSegments(urlPath, rawQuery)
+	return filepath.Join(append([]string{outputDir}, segments...)...)
 }
 
-// GetLocalFilePath determines the local file path for a given URL
-func GetLocalFilePath(urlStr string, outputDir string) string {
+// GetLocalFilePath determines the local file path for a given URL. When
+// includeHost is set the host name becomes the top-level directory so files
+// from different hosts don't collide. It returns "" for URLs that can't be
+// mapped to a path inside outputDir.
+func GetLocalFilePath(urlStr string, outputDir string, includeHost bool) string {
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
 		return ""
 	}
 
-	return convertURLPathToLocalPath(parsedURL.Path, outputDir)
+	if includeHost {
+		host := sanitizeSegment(parsedURL.Host)
+		if host == "" || host == "." || host == ".." {
+			return ""
+		}
+		outputDir = filepath.Join(outputDir, host)
+	}
+
+	return convertURLPathToLocalPath(parsedURL.Path, parsedURL.RawQuery, outputDir)
 }
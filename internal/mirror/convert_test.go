@@ -0,0 +1,77 @@
+package mirror
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// saved maps URLs to their local files under /out
+func saved(files map[string]string) LocalPathFunc {
+	return func(urlStr string) string {
+		if name, ok := files[urlStr]; ok {
+			return filepath.Join("/out", filepath.FromSlash(name))
+		}
+		return ""
+	}
+}
+
+func TestConvertLinksRewritesExactSpans(t *testing.T) {
+	pageURL, _ := url.Parse("http://example.com/index.html")
+	localPath := saved(map[string]string{
+		"http://example.com/a":        "a.html",
+		"http://example.com/a/b.html": "a/b.html",
+	})
+
+	content := `<a href="http://example.com/a">A</a>` +
+		`<a href="http://example.com/a/b.html">B</a>` +
+		`<script>var links = {"home": "http://example.com/a"};</script>` +
+		`<script type="application/json">["http://example.com/a/b.html"]</script>`
+	want := `<a href="a.html">A</a>` +
+		`<a href="a/b.html">B</a>` +
+		`<script>var links = {"home": "http://example.com/a"};</script>` +
+		`<script type="application/json">["http://example.com/a/b.html"]</script>`
+
+	got := ConvertLinks(content, pageURL, "/out/index.html", localPath)
+	if got != want {
+		t.Errorf("ConvertLinks:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestConvertCSSLinksRewritesExactSpans(t *testing.T) {
+	pageURL, _ := url.Parse("http://example.com/css/site.css")
+	localPath := saved(map[string]string{
+		"http://example.com/img/bg.png": "img/bg.png",
+	})
+
+	content := `body { background: url(/img/bg.png) }` +
+		` .big { background: url("/img/bg.png.large") }` +
+		` /* fallback: /img/bg.png */`
+	want := `body { background: url(../img/bg.png) }` +
+		` .big { background: url("http://example.com/img/bg.png.large") }` +
+		` /* fallback: /img/bg.png */`
+
+	got := ConvertCSSLinks(content, pageURL, "/out/css/site.css", localPath)
+	if got != want {
+		t.Errorf("ConvertCSSLinks:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestConvertLinksRemovesBaseHref(t *testing.T) {
+	pageURL, _ := url.Parse("http://example.com/docs/index.html")
+	localPath := saved(map[string]string{
+		"http://example.com/static/logo.png":  "static/logo.png",
+		"http://example.com/static/page.html": "static/page.html",
+	})
+
+	content := `<html><head><base href="/static/" target="_blank"><title>Docs</title></head>` +
+		`<body><img src="logo.png"><a href="page.html">Page</a><a href="other.html">Other</a></body></html>`
+	want := `<html><head><title>Docs</title></head>` +
+		`<body><img src="../static/logo.png"><a href="../static/page.html">Page</a>` +
+		`<a href="http://example.com/static/other.html">Other</a></body></html>`
+
+	got := ConvertLinks(content, pageURL, "/out/docs/index.html", localPath)
+	if got != want {
+		t.Errorf("ConvertLinks:\n got %s\nwant %s", got, want)
+	}
+}
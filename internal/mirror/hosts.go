@@ -0,0 +1,38 @@
+package mirror
+
+import (
+	"net/url"
+	"strings"
+)
+
+// HostFilter decides which hosts a mirror may download from
+type HostFilter struct {
+	BaseHost  string   // Host of the start URL, always allowed
+	SpanHosts bool     // Follow links to other hosts
+	Domains   []string // When spanning, restrict to these domains and their subdomains
+}
+
+// Allows reports whether resources on the URL's host belong to the mirror
+func (f *HostFilter) Allows(u *url.URL) bool {
+	if u.Host == f.BaseHost {
+		return true
+	}
+	if !f.SpanHosts || u.Host == "" {
+		return false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	if len(f.Domains) == 0 {
+		return true
+	}
+
+	hostname := strings.ToLower(u.Hostname())
+	for _, domain := range f.Domains {
+		domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+		if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,44 +4,121 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/rand"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
+	"wget/internal/httpclient"
 	"wget/internal/logging"
+	"wget/internal/ratelimit"
 
 	"golang.org/x/time/rate"
 )
 
 type Options struct {
-	RejectTypes  []string
-	ExcludeDirs  []string
-	ConvertLinks bool
-	OutputPath   string
-	RateLimit    string
-	MaxDepth     int
-	MaxFiles     int
+	AcceptTypes        []string
+	RejectTypes        []string
+	IncludeDirs        []string
+	ExcludeDirs        []string
+	ConvertLinks       bool
+	SpanHosts          bool
+	Domains            []string
+	OutputPath         string
+	RateLimit          string
+	Quota              int64 // Total bytes to download, 0 for no limit
+	MaxDepth           int   // Levels of links to follow, 0 uses DefaultMaxDepth and Unlimited removes the limit
+	MaxFiles           int   // Files to save, 0 uses DefaultMaxFiles
+	ConnectTimeout     time.Duration
+	ReadTimeout        time.Duration
+	UserAgent          string
+	Headers            http.Header
+	User               string
+	Password           string
+	Netrc              *httpclient.Netrc // Logins used for hosts when User is empty, may be nil
+	Proxy              string
+	BindAddress        string
+	Network            string // "tcp4" or "tcp6" to connect only over IPv4 or IPv6, "" for either
+	NoCheckCertificate bool
+	Certificate        string // Client certificate file, see httpclient.Options
+	PrivateKey         string
+	CACertificate      string
+	CookieJar          http.CookieJar
+	MaxRedirects       int           // Longest redirect chain followed, see httpclient.Options
+	Wait               time.Duration // Delay between requests
+	RandomWait         bool          // Vary the delay between 0.5x and 1.5x of Wait
+	NoClobber          bool          // Keep files that already exist locally
+	Timestamping       bool          // Only download files newer than the local copy
+	PreserveTimestamp  bool          // Give saved files the server's Last-Modified time
+	NoParent           bool          // Stay inside the start URL's directory on its host
+	AdjustExtension    bool          // Save HTML and CSS responses with a .html or .css suffix
+	PageRequisites     bool          // Fetch the assets of saved pages even past MaxDepth
+	Spider             bool          // Crawl and report broken links without saving anything
+	ServerResponse     bool          // Log the status line and headers of each response
+	Sitemap            bool          // Queue the pages listed in the site's sitemap.xml before crawling
+	Client             *http.Client  // HTTP client for the crawl; when nil one is built from the connection options above
 }
 
+const (
+	// DefaultMaxDepth is the depth limit when none is configured
+	DefaultMaxDepth = 5
+	// DefaultMaxFiles is the file limit when none is configured
+	DefaultMaxFiles = 1000
+	// Unlimited as MaxDepth follows links to any depth
+	Unlimited = -1
+)
+
+// progressInterval is how often a crawl progress line is logged
+const progressInterval = 5 * time.Second
+
+// throttleTries is how many times a URL is requested while the server keeps
+// answering 429 or 503 with a Retry-After header
+const throttleTries = 3
+
 type MirrorState struct {
-	baseURL      *url.URL
-	visited      map[string]bool
-	pending      []string
-	downloaded   map[string]string // URL -> local file path
-	mutex        sync.RWMutex
-	fileCount    int
-	client       *http.Client
-	limiter      *rate.Limiter
-	logger       *logging.Logger
+	baseURL     *url.URL
+	hosts       *HostFilter
+	visited     map[string]bool
+	pending     []string
+	downloaded  map[string]string // URL -> local file path
+	redirects   map[string]string // Requested URL -> URL it redirected to
+	requisites  map[string]bool   // URLs queued as assets of a saved page
+	referrers   map[string]string // URL -> page it was first found on
+	brokenLinks []brokenLink      // URLs that failed, in the order they were fetched
+	checked     int               // URLs requested in spider mode
+	mutex       sync.RWMutex
+	fileCount   int
+	bytes       int64     // Bytes received, counted against options.Quota
+	requests    int       // Requests issued so far, used to skip the first wait
+	startURL    string    // Key of this crawl in the state file
+	authHost    string    // Host that receives options.User and options.Password
+	depth       int       // Level being crawled
+	current     []string  // URLs of the current level not yet processed
+	resumed     []string  // Next level URLs restored from the state file
+	lastSave    time.Time // When the state file was last written
+	lastReport  time.Time // When crawl progress was last logged
+	client      *http.Client
+	limiter     *rate.Limiter
+	logger      *logging.Logger
 }
 
-// MirrorWebsite downloads an entire website with recursive crawling
-func MirrorWebsite(urlStr string, options *Options, logger *logging.Logger) error {
+// MirrorWebsite downloads an entire website with recursive crawling. Cancelling
+// ctx stops the crawl; pages already saved are kept.
+func MirrorWebsite(ctx context.Context, urlStr string, options *Options, logger *logging.Logger) error {
 	logger.LogStart()
-	logger.Printf("Starting website mirroring for: %s\n", urlStr)
+
+	assumedScheme := httpclient.MissingScheme(urlStr)
+	if assumedScheme {
+		logger.Printf("No scheme given, assuming %s\n", logging.RedactURL("https://"+urlStr))
+		urlStr = "https://" + urlStr
+	}
+	urlStr = cleanURL(urlStr)
+	logger.Printf("Starting website mirroring for: %s\n", logging.RedactURL(urlStr))
 
 	// Parse base URL
 	baseURL, err := url.Parse(urlStr)
@@ -51,46 +128,127 @@ func MirrorWebsite(urlStr string, options *Options, logger *logging.Logger) erro
 
 	// Set default values
 	if options.MaxDepth == 0 {
-		options.MaxDepth = 5 // Default depth limit
+		options.MaxDepth = DefaultMaxDepth
 	}
 	if options.MaxFiles == 0 {
-		options.MaxFiles = 1000 // Default file limit
+		options.MaxFiles = DefaultMaxFiles
 	}
 	if options.OutputPath == "" {
-		options.OutputPath = baseURL.Host
+		// When spanning hosts every host gets its own directory anyway
+		if options.SpanHosts {
+			options.OutputPath = "."
+		} else {
+			options.OutputPath = baseURL.Host
+		}
 	}
 
-	// Create output directory
-	err = os.MkdirAll(options.OutputPath, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
+	// Create output directory, unless spider mode will write nothing
+	if !options.Spider {
+		err = os.MkdirAll(options.OutputPath, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create output directory: %v", err)
+		}
+	}
+
+	client := options.Client
+	if client == nil {
+		client, err = httpclient.New(&httpclient.Options{
+			ConnectTimeout:     options.ConnectTimeout,
+			ReadTimeout:        options.ReadTimeout,
+			Proxy:              options.Proxy,
+			BindAddress:        options.BindAddress,
+			Network:            options.Network,
+			NoCheckCertificate: options.NoCheckCertificate,
+			Certificate:        options.Certificate,
+			PrivateKey:         options.PrivateKey,
+			CACertificate:      options.CACertificate,
+			Jar:                options.CookieJar,
+			MaxRedirects:       options.MaxRedirects,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Crawl over http:// when the assumed https:// server can't be reached
+	if assumedScheme {
+		if err := probeURL(ctx, client, urlStr, options); err != nil && ctx.Err() == nil {
+			logger.Printf("%s failed: %v, falling back to http://\n", logging.RedactURL(urlStr), err)
+			baseURL.Scheme = "http"
+			urlStr = baseURL.String()
+		}
 	}
 
 	// Initialize mirror state
 	state := &MirrorState{
-		baseURL:    baseURL,
+		baseURL: baseURL,
+		hosts: &HostFilter{
+			BaseHost:  baseURL.Host,
+			SpanHosts: options.SpanHosts,
+			Domains:   options.Domains,
+		},
 		visited:    make(map[string]bool),
 		pending:    []string{urlStr},
 		downloaded: make(map[string]string),
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		logger: logger,
+		redirects:  make(map[string]string),
+		requisites: make(map[string]bool),
+		referrers:  make(map[string]string),
+		startURL:   urlStr,
+		authHost:   baseURL.Host,
+		lastSave:   time.Now(),
+		lastReport: time.Now(),
+		client:     client,
+		logger:     logger,
 	}
 
-	// Set up rate limiting
+	// One limiter caps the bandwidth of the whole crawl
 	if options.RateLimit != "" {
-		state.limiter, err = parseRateLimit(options.RateLimit)
+		state.limiter, err = ratelimit.Parse(options.RateLimit)
+		if err != nil {
+			return fmt.Errorf("invalid rate limit: %v", err)
+		}
+	}
+
+	// Pick up an interrupted crawl of the same start URL. Spider mode keeps
+	// no state since it saves nothing.
+	resumed := false
+	if !options.Spider {
+		resumed, err = state.loadState(urlStr, options)
 		if err != nil {
-			logger.Printf("Warning: Invalid rate limit, proceeding without rate limiting: %v\n", err)
+			logger.Printf("Warning: failed to load crawl state: %v\n", err)
+		} else if resumed {
+			logger.Printf("Resuming interrupted mirror at depth %d: %d URLs visited, %d files saved\n",
+				state.depth, len(state.visited), state.fileCount)
 		}
 	}
 
+	// A resumed crawl already queued the sitemap's pages
+	if options.Sitemap && !resumed {
+		state.seedFromSitemap(ctx, options)
+	}
+
 	// Start mirroring process
-	err = state.mirror(options, 0)
+	err = state.mirror(ctx, options, state.depth)
 	if err != nil {
+		if ctx.Err() != nil && !options.Spider {
+			if saveErr := state.saveState(urlStr, options); saveErr != nil {
+				logger.Printf("Warning: failed to save crawl state: %v\n", saveErr)
+			} else {
+				logger.Printf("Crawl state saved to %s, run the same command again to resume\n", statePath(options))
+			}
+		}
 		return err
 	}
+	if !options.Spider {
+		if err := state.clearState(urlStr, options); err != nil {
+			logger.Printf("Warning: failed to clear crawl state: %v\n", err)
+		}
+	}
+
+	// Spider mode reports links instead of files
+	if options.Spider {
+		return state.reportBrokenLinks(options)
+	}
 
 	// Convert links if requested
 	if options.ConvertLinks {
@@ -101,98 +259,460 @@ func MirrorWebsite(urlStr string, options *Options, logger *logging.Logger) erro
 		}
 	}
 
+	state.reportBrokenLinks(options)
+
 	logger.Printf("Website mirroring completed! Downloaded %d files to %s\n", state.fileCount, options.OutputPath)
 	return nil
 }
 
-// mirror performs the recursive crawling and downloading
-func (s *MirrorState) mirror(options *Options, depth int) error {
-	if depth >= options.MaxDepth {
-		s.logger.Printf("Reached maximum depth (%d), stopping recursion\n", options.MaxDepth)
-		return nil
+// probeURL sends a HEAD request to check that the server can be reached at
+// all. Any response, whatever its status, counts as success.
+func probeURL(ctx context.Context, client *http.Client, urlStr string, options *Options) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, urlStr, nil)
+	if err != nil {
+		return err
+	}
+	httpclient.SetUserAgent(req, options.UserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return logging.RedactError(err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// setBasicAuth adds the configured credentials to req. The explicit user and
+// password only go to the start URL's host, so spanning hosts can't leak them
+// to third parties; other hosts get their .netrc login, if any.
+func (s *MirrorState) setBasicAuth(req *http.Request, options *Options) {
+	user, password := options.User, options.Password
+	if req.URL.Host != s.authHost {
+		user, password = "", ""
+	}
+	httpclient.SetBasicAuth(req, user, password, options.Netrc)
+}
+
+// mirror performs the recursive crawling and downloading. With
+// --page-requisites, levels past MaxDepth still fetch the assets of the pages
+// saved before them.
+func (s *MirrorState) mirror(ctx context.Context, options *Options, depth int) error {
+	if options.MaxDepth != Unlimited && depth >= options.MaxDepth {
+		if depth == options.MaxDepth {
+			s.logger.Printf("Reached maximum depth (%d), stopping recursion\n", options.MaxDepth)
+		}
+		if !options.PageRequisites {
+			return nil
+		}
+		s.pending = s.onlyRequisites(s.pending)
+		if len(s.pending) == 0 {
+			return nil
+		}
 	}
 
 	if s.fileCount >= options.MaxFiles {
 		s.logger.Printf("Reached maximum file limit (%d), stopping download\n", options.MaxFiles)
 		return nil
 	}
+	if s.quotaReached(options) {
+		return nil
+	}
 
-	// Process all pending URLs at current depth
+	// Process all pending URLs at current depth. Links found by an
+	// interrupted run join those found now.
 	currentLevel := make([]string, len(s.pending))
 	copy(currentLevel, s.pending)
-	s.pending = nil
+	s.pending, s.resumed = s.resumed, nil
+	s.depth = depth
 
-	for _, urlStr := range currentLevel {
+	for i, urlStr := range currentLevel {
 		if s.fileCount >= options.MaxFiles {
 			break
 		}
+		// The file that crossed the quota is kept, but nothing more is fetched
+		if s.quotaReached(options) {
+			s.current = nil
+			return nil
+		}
+		s.current = currentLevel[i:]
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		// Skip if already visited
+		// Skip if already visited under this or an equivalent URL
+		key := urlKey(urlStr)
 		s.mutex.Lock()
-		if s.visited[urlStr] {
+		if s.visited[key] {
 			s.mutex.Unlock()
 			continue
 		}
-		s.visited[urlStr] = true
+		s.visited[key] = true
 		s.mutex.Unlock()
 
+		// Pause between requests to avoid hammering the server
+		s.waitBeforeRequest(ctx, options)
+
 		// Download and process the URL
-		err := s.processURL(urlStr, options)
+		err := s.processURL(ctx, urlStr, options)
+
+		// An interrupted URL is fetched again when the crawl resumes
+		if ctx.Err() != nil {
+			s.mutex.Lock()
+			delete(s.visited, key)
+			s.mutex.Unlock()
+			return ctx.Err()
+		}
+		s.current = currentLevel[i+1:]
+		s.saveStatePeriodically(options)
+		s.reportProgressPeriodically()
+
 		if err != nil {
-			s.logger.Printf("Warning: Failed to process %s: %v\n", urlStr, err)
+			s.logger.Printf("Warning: Failed to process %s: %v\n", logging.RedactURL(urlStr), err)
 			continue
 		}
 	}
+	s.current = nil
 
 	// Recurse to next depth level if there are pending URLs
 	if len(s.pending) > 0 {
-		return s.mirror(options, depth+1)
+		return s.mirror(ctx, options, depth+1)
 	}
 
 	return nil
 }
 
+// quotaReached reports whether the bytes received have used up
+// options.Quota, logging that the crawl stops
+func (s *MirrorState) quotaReached(options *Options) bool {
+	if options.Quota <= 0 || s.bytes < options.Quota {
+		return false
+	}
+	s.logger.Printf("Download quota of %s reached after %s, stopping download\n",
+		logging.FormatBytes(options.Quota), logging.FormatBytes(s.bytes))
+	return true
+}
+
+// saveStatePeriodically writes the state file when stateSaveInterval has
+// passed since the last save, so a crash loses little of the crawl
+func (s *MirrorState) saveStatePeriodically(options *Options) {
+	if options.Spider || time.Since(s.lastSave) < stateSaveInterval {
+		return
+	}
+	if err := s.saveState(s.startURL, options); err != nil {
+		s.logger.Printf("Warning: failed to save crawl state: %v\n", err)
+	}
+}
+
+// reportProgressPeriodically logs how far the crawl has got when
+// progressInterval has passed since the last report
+func (s *MirrorState) reportProgressPeriodically() {
+	if time.Since(s.lastReport) < progressInterval {
+		return
+	}
+	s.lastReport = time.Now()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	s.logger.LogCrawlProgress(s.fileCount, len(s.current), len(s.pending), s.depth)
+}
+
+// onlyRequisites returns the URLs that were queued as page requisites
+func (s *MirrorState) onlyRequisites(urls []string) []string {
+	var requisites []string
+	for _, urlStr := range urls {
+		if s.requisites[urlStr] {
+			requisites = append(requisites, urlStr)
+		}
+	}
+	return requisites
+}
+
+// waitBeforeRequest sleeps for the configured wait time, except before the first request
+func (s *MirrorState) waitBeforeRequest(ctx context.Context, options *Options) {
+	s.requests++
+	if s.requests == 1 || options.Wait <= 0 {
+		return
+	}
+
+	delay := options.Wait
+	if options.RandomWait {
+		delay = time.Duration(float64(delay) * (0.5 + rand.Float64()))
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+}
+
 // processURL downloads a single URL and extracts resources from it
-func (s *MirrorState) processURL(urlStr string, options *Options) error {
-	// Rate limiting
-	if s.limiter != nil {
-		err := s.limiter.Wait(context.Background())
-		if err != nil {
-			return err
+func (s *MirrorState) processURL(ctx context.Context, urlStr string, options *Options) error {
+	// Reuse files from an earlier run instead of downloading them again
+	if options.NoClobber && !options.Spider {
+		if localPath, info := existingLocalPath(urlStr, options); info != nil {
+			content, err := os.ReadFile(localPath)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", localPath, err)
+			}
+			s.logger.LogNoClobber(localPath)
+			return s.reuseExisting(urlStr, localPath, content, options)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Spider mode only needs the status of resources it won't parse for links
+	method := http.MethodGet
+	if options.Spider && !hasLinks(urlStr, "") {
+		method = http.MethodHead
+	}
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request for %s: %v", logging.RedactURL(urlStr), err)
+	}
+	httpclient.SetUserAgent(req, options.UserAgent)
+	httpclient.SetHeaders(req, options.Headers)
+	s.setBasicAuth(req, options)
+
+	// Ask the server to skip files that haven't changed since the last run
+	var localPath string
+	var localInfo os.FileInfo
+	if options.Timestamping && !options.Spider {
+		localPath, localInfo = existingLocalPath(urlStr, options)
+		if localInfo != nil {
+			req.Header.Set("If-Modified-Since", localInfo.ModTime().UTC().Format(http.TimeFormat))
 		}
 	}
 
 	// Download the content
-	resp, err := s.client.Get(urlStr)
+	if options.Spider {
+		s.checked++
+	}
+	resp, err := s.doWithRetryAfter(ctx, req)
+	if err == nil && method == http.MethodHead && needsGet(resp) {
+		resp.Body.Close()
+		req.Method = http.MethodGet
+		resp, err = s.doWithRetryAfter(ctx, req)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to fetch %s: %v", urlStr, err)
+		if ctx.Err() == nil {
+			s.recordBroken(urlStr, 0, err.Error())
+		}
+		return fmt.Errorf("failed to fetch %s: %v", logging.RedactURL(urlStr), err)
 	}
 	defer resp.Body.Close()
 
+	if options.ServerResponse {
+		s.logger.Printf("%s:\n", logging.RedactURL(urlStr))
+		s.logger.LogServerResponse(resp)
+	}
+
+	// 304 is expected when timestamping and is not a broken link
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotModified {
+		s.recordBroken(urlStr, resp.StatusCode, resp.Status)
+	}
+
+	if resp.StatusCode == http.StatusNotModified && localInfo != nil {
+		content, err := os.ReadFile(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", localPath, err)
+		}
+		s.logger.LogNotModified(localPath)
+		return s.reuseExisting(urlStr, localPath, content, options)
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP %d for %s", resp.StatusCode, urlStr)
+		return fmt.Errorf("HTTP %d for %s", resp.StatusCode, logging.RedactURL(urlStr))
+	}
+
+	// Save redirected pages under the URL they were finally served from
+	if finalURL := resp.Request.URL; finalURL.String() != urlStr {
+		if done, err := s.followRedirect(urlStr, finalURL); done || err != nil {
+			return err
+		}
+		urlStr = finalURL.String()
+	}
+
+	// Spider mode only needs the bodies it can find links in
+	contentType := resp.Header.Get("Content-Type")
+	if options.Spider && !hasLinks(urlStr, contentType) {
+		return nil
 	}
 
-	// Read content
-	content, err := io.ReadAll(resp.Body)
+	// Read content, giving up if the server stalls and throttling to the rate limit
+	body := httpclient.NewIdleReader(resp.Body, options.ReadTimeout, cancel)
+	defer body.Stop()
+	content, err := io.ReadAll(ratelimit.NewReader(ctx, body, s.limiter))
 	if err != nil {
-		return fmt.Errorf("failed to read content from %s: %v", urlStr, err)
+		return fmt.Errorf("failed to read content from %s: %v", logging.RedactURL(urlStr), err)
 	}
+	s.mutex.Lock()
+	s.bytes += int64(len(content))
+	s.mutex.Unlock()
 
+	// Save the content unless an accept list rules it out; pages are still
+	// parsed below so the crawl can reach accepted files. Spider mode writes
+	// nothing.
+	switch {
+	case options.Spider:
+	case len(options.AcceptTypes) == 0 || MatchesExtension(urlStr, options.AcceptTypes) ||
+		matchesType(typeExtension(urlStr, contentType), options.AcceptTypes):
+		var modTime time.Time
+		if options.Timestamping || options.PreserveTimestamp {
+			modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+		}
+		err = s.saveContent(urlStr, content, contentType, modTime, options)
+		if err != nil {
+			return err
+		}
+	default:
+		s.logger.Printf("Not saving %s: not in accept list\n", logging.RedactURL(urlStr))
+	}
+
+	s.extractResources(content, urlStr, contentType, options)
+	return nil
+}
+
+// doWithRetryAfter sends the request, waiting and trying again when the
+// server answers 429 or 503 with a Retry-After header, up to throttleTries
+// times. The last response is returned whatever its status.
+func (s *MirrorState) doWithRetryAfter(ctx context.Context, req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := s.client.Do(req.Clone(ctx))
+		if err != nil {
+			return nil, logging.RedactError(err)
+		}
+		wait, ok := httpclient.RetryAfter(resp)
+		if !ok || attempt >= throttleTries {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		s.logger.LogRetry(attempt, throttleTries, wait, fmt.Errorf("server returned status: %s", resp.Status))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// followRedirect records that urlStr redirected to finalURL, so links to
+// urlStr can be converted to the final URL's local file. A redirect of the
+// start URL moves the mirror to the new location. It returns true when there
+// is nothing left to do because the target is off-site or already handled.
+func (s *MirrorState) followRedirect(urlStr string, finalURL *url.URL) (bool, error) {
+	if urlStr == s.baseURL.String() {
+		s.baseURL = finalURL
+		s.hosts.BaseHost = finalURL.Host
+	} else if !s.hosts.Allows(finalURL) {
+		return true, fmt.Errorf("%s redirected to %s on another host", logging.RedactURL(urlStr), logging.RedactURL(finalURL.String()))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.redirects[urlStr] = finalURL.String()
+
+	// A redirect that only adds or drops a trailing slash stays on the URL
+	// already claimed; any other target may have been fetched already
+	finalKey := urlKey(finalURL.String())
+	if finalKey != urlKey(urlStr) {
+		if s.visited[finalKey] {
+			return true, nil
+		}
+		s.visited[finalKey] = true
+	}
+
+	s.logger.Printf("Redirected: %s -> %s\n", logging.RedactURL(urlStr), logging.RedactURL(finalURL.String()))
+	return false, nil
+}
+
+// reuseExisting records a file left by an earlier run and crawls its links
+// so the rest of the site is still reached
+func (s *MirrorState) reuseExisting(urlStr, localPath string, content []byte, options *Options) error {
+	s.mutex.Lock()
+	s.downloaded[urlStr] = localPath
+	s.mutex.Unlock()
+
+	s.extractResources(content, urlStr, mime.TypeByExtension(filepath.Ext(localPath)), options)
+	return nil
+}
+
+// extractResources parses HTML and CSS content for additional resources to queue
+func (s *MirrorState) extractResources(content []byte, urlStr, contentType string, options *Options) {
+	var err error
+	if isHTML(urlStr, contentType) {
+		err = s.extractHTMLResources(string(content), urlStr, options)
+		if err != nil {
+			s.logger.Printf("Warning: Failed to extract resources from %s: %v\n", logging.RedactURL(urlStr), err)
+		}
+	} else if isCSS(urlStr, contentType) {
+		err = s.extractCSSResources(string(content), urlStr, options)
+		if err != nil {
+			s.logger.Printf("Warning: Failed to extract CSS resources from %s: %v\n", logging.RedactURL(urlStr), err)
+		}
+	}
+
+}
+
+// isHTML reports whether a response is an HTML page, judging by its content
+// type or, failing that, its URL, see determineResourceType
+func isHTML(urlStr, contentType string) bool {
+	return determineResourceType(urlStr, contentType) == HTML
+}
+
+// isCSS reports whether a response is a stylesheet
+func isCSS(urlStr, contentType string) bool {
+	return determineResourceType(urlStr, contentType) == CSS
+}
+
+// hasLinks reports whether a resource is parsed for links, as HTML pages and
+// stylesheets are
+func hasLinks(urlStr, contentType string) bool {
+	return isHTML(urlStr, contentType) || isCSS(urlStr, contentType)
+}
+
+// needsGet reports whether a spider's HEAD request must be repeated as a GET:
+// the server doesn't support HEAD, or the response turned out to be a page
+// whose links are needed
+func needsGet(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	case http.StatusOK:
+		return hasLinks(resp.Request.URL.String(), resp.Header.Get("Content-Type"))
+	}
+	return false
+}
+
+// saveContent writes downloaded content to its local path and records it. A
+// non-zero modTime becomes the file's modification time.
+func (s *MirrorState) saveContent(urlStr string, content []byte, contentType string, modTime time.Time, options *Options) error {
 	// Determine local file path
-	localPath := GetLocalFilePath(urlStr, options.OutputPath)
-	
+	localPath := GetLocalFilePath(urlStr, options.OutputPath, options.SpanHosts)
+	if localPath == "" {
+		return fmt.Errorf("no safe local file name for %s", logging.RedactURL(urlStr))
+	}
+	localPath += typeExtension(urlStr, contentType)
+	if options.AdjustExtension {
+		localPath = adjustExtension(localPath, contentType)
+	}
+
 	// Create directory structure
-	err = os.MkdirAll(filepath.Dir(localPath), 0755)
+	err := os.MkdirAll(filepath.Dir(localPath), 0755)
 	if err != nil {
 		return fmt.Errorf("failed to create directory structure: %v", err)
 	}
 
 	// Save content to file
-	err = os.WriteFile(localPath, content, 0644)
+	err = writeFileAtomic(localPath, content)
 	if err != nil {
 		return fmt.Errorf("failed to save file %s: %v", localPath, err)
 	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(localPath, modTime, modTime); err != nil {
+			s.logger.Printf("Warning: Failed to set modification time of %s: %v\n", localPath, err)
+		}
+	}
 
 	// Record the download
 	s.mutex.Lock()
@@ -200,23 +720,32 @@ func (s *MirrorState) processURL(urlStr string, options *Options) error {
 	s.fileCount++
 	s.mutex.Unlock()
 
-	s.logger.Printf("Downloaded: %s -> %s\n", urlStr, localPath)
+	s.logger.Printf("Downloaded: %s -> %s\n", logging.RedactURL(urlStr), localPath)
+	return nil
+}
 
-	// Parse content for additional resources (only for HTML and CSS)
-	contentType := resp.Header.Get("Content-Type")
-	if strings.Contains(contentType, "text/html") || strings.HasSuffix(urlStr, ".html") {
-		err = s.extractHTMLResources(string(content), urlStr, options)
-		if err != nil {
-			s.logger.Printf("Warning: Failed to extract resources from %s: %v\n", urlStr, err)
-		}
-	} else if strings.Contains(contentType, "text/css") || strings.HasSuffix(urlStr, ".css") {
-		err = s.extractCSSResources(string(content), urlStr, options)
-		if err != nil {
-			s.logger.Printf("Warning: Failed to extract CSS resources from %s: %v\n", urlStr, err)
-		}
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so a failed write never leaves a truncated file or destroys an
+// existing copy
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.part")
+	if err != nil {
+		return err
 	}
-
-	return nil
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
 }
 
 // extractHTMLResources extracts and queues resources from HTML content
@@ -232,23 +761,28 @@ func (s *MirrorState) extractHTMLResources(content, baseURLStr string, options *
 	}
 
 	// Filter resources
-	filtered := FilterResources(resources, options.RejectTypes, options.ExcludeDirs)
+	filtered := FilterResources(resources, options.RejectTypes, options.IncludeDirs, options.ExcludeDirs, options.AcceptTypes)
 
 	// Add new resources to pending queue
 	s.mutex.Lock()
 	for _, resource := range filtered {
-		// Only queue resources from the same domain
+		// Only queue resources from hosts that are part of the mirror
 		resURL, err := url.Parse(resource.URL)
 		if err != nil {
 			continue
 		}
-		if resURL.Host != s.baseURL.Host {
+		if !s.hosts.Allows(resURL) || !s.withinStartDir(resURL, options) {
 			continue
 		}
 
-		// Skip if already visited or pending
-		if !s.visited[resource.URL] {
-			s.pending = append(s.pending, resource.URL)
+		// Skip if already visited
+		resourceURL := cleanURL(resource.URL)
+		if !s.visited[urlKey(resourceURL)] {
+			s.pending = append(s.pending, resourceURL)
+			s.recordReferrer(resourceURL, baseURLStr)
+			if options.PageRequisites && resource.Requisite {
+				s.requisites[resourceURL] = true
+			}
 		}
 	}
 	s.mutex.Unlock()
@@ -256,6 +790,20 @@ func (s *MirrorState) extractHTMLResources(content, baseURLStr string, options *
 	return nil
 }
 
+// withinStartDir reports whether a URL may be crawled under --no-parent. Only
+// the start host is confined; other hosts reached with --span-hosts have no
+// start directory to stay under.
+func (s *MirrorState) withinStartDir(u *url.URL, options *Options) bool {
+	if !options.NoParent || u.Host != s.baseURL.Host {
+		return true
+	}
+
+	// The start directory is everything up to the last slash of the path
+	startDir := s.baseURL.Path
+	startDir = startDir[:strings.LastIndex(startDir, "/")+1]
+	return strings.HasPrefix(u.Path, startDir)
+}
+
 // extractCSSResources extracts and queues resources from CSS content
 func (s *MirrorState) extractCSSResources(content, baseURLStr string, options *Options) error {
 	baseURL, err := url.Parse(baseURLStr)
@@ -269,23 +817,28 @@ func (s *MirrorState) extractCSSResources(content, baseURLStr string, options *O
 	}
 
 	// Filter resources
-	filtered := FilterResources(resources, options.RejectTypes, options.ExcludeDirs)
+	filtered := FilterResources(resources, options.RejectTypes, options.IncludeDirs, options.ExcludeDirs, options.AcceptTypes)
 
 	// Add new resources to pending queue
 	s.mutex.Lock()
 	for _, resource := range filtered {
-		// Only queue resources from the same domain
+		// Only queue resources from hosts that are part of the mirror
 		resURL, err := url.Parse(resource.URL)
 		if err != nil {
 			continue
 		}
-		if resURL.Host != s.baseURL.Host {
+		if !s.hosts.Allows(resURL) || !s.withinStartDir(resURL, options) {
 			continue
 		}
 
-		// Skip if already visited or pending
-		if !s.visited[resource.URL] {
-			s.pending = append(s.pending, resource.URL)
+		// Skip if already visited
+		resourceURL := cleanURL(resource.URL)
+		if !s.visited[urlKey(resourceURL)] {
+			s.pending = append(s.pending, resourceURL)
+			s.recordReferrer(resourceURL, baseURLStr)
+			if options.PageRequisites && resource.Requisite {
+				s.requisites[resourceURL] = true
+			}
 		}
 	}
 	s.mutex.Unlock()
@@ -295,7 +848,21 @@ func (s *MirrorState) extractCSSResources(content, baseURLStr string, options *O
 
 // convertAllLinks converts all links in downloaded files for offline browsing
 func (s *MirrorState) convertAllLinks(options *Options) error {
-	for _, localPath := range s.downloaded {
+	localPathFor := s.localPathFunc()
+	converted := make(map[string]bool, len(s.downloaded))
+	for urlStr, localPath := range s.downloaded {
+		// URLs such as / and /index.html share a file, which must only be
+		// converted once
+		if converted[localPath] {
+			continue
+		}
+		converted[localPath] = true
+
+		pageURL, err := url.Parse(urlStr)
+		if err != nil {
+			continue
+		}
+
 		// Read file content
 		content, err := os.ReadFile(localPath)
 		if err != nil {
@@ -306,15 +873,15 @@ func (s *MirrorState) convertAllLinks(options *Options) error {
 		// Convert links based on file type
 		var convertedContent string
 		if strings.HasSuffix(localPath, ".html") || strings.HasSuffix(localPath, ".htm") {
-			convertedContent = ConvertLinks(string(content), s.baseURL, options.OutputPath, localPath)
+			convertedContent = ConvertLinks(string(content), pageURL, localPath, localPathFor)
 		} else if strings.HasSuffix(localPath, ".css") {
-			convertedContent = ConvertCSSLinks(string(content), s.baseURL, options.OutputPath, localPath)
+			convertedContent = ConvertCSSLinks(string(content), pageURL, localPath, localPathFor)
 		} else {
 			continue // Skip non-HTML/CSS files
 		}
 
 		// Write converted content back to file
-		err = os.WriteFile(localPath, []byte(convertedContent), 0644)
+		err = writeFileAtomic(localPath, []byte(convertedContent))
 		if err != nil {
 			s.logger.Printf("Warning: Failed to write converted content to %s: %v\n", localPath, err)
 		}
@@ -323,47 +890,126 @@ func (s *MirrorState) convertAllLinks(options *Options) error {
 	return nil
 }
 
-// parseRateLimit parses rate limit string and returns a rate limiter
-func parseRateLimit(rateStr string) (*rate.Limiter, error) {
-	// Use our simple rate limit parser directly
-	return parseRateLimitSimple(rateStr)
-}
+// localPathFunc returns the local file for a URL during link conversion.
+// URLs are matched by urlKey, so a link to /page/ finds the file saved for
+// /page. Redirected URLs point at the file saved for their target. URLs that
+// were never saved, because they were rejected, failed or fell past a limit,
+// have no local file, so links to them stay usable online instead of dangling.
+func (s *MirrorState) localPathFunc() LocalPathFunc {
+	saved := make(map[string]string, len(s.downloaded))
+	for urlStr, localPath := range s.downloaded {
+		saved[urlKey(urlStr)] = localPath
+	}
+	redirects := make(map[string]string, len(s.redirects))
+	for urlStr, target := range s.redirects {
+		redirects[urlKey(urlStr)] = urlKey(target)
+	}
 
-// parseRateLimitSimple provides a simple rate limit parser
-func parseRateLimitSimple(rateStr string) (*rate.Limiter, error) {
-	rateStr = strings.ToLower(strings.TrimSpace(rateStr))
-	
-	var bytesPerSecond float64
-	
-	if strings.HasSuffix(rateStr, "k") {
-		// Parse kilobytes per second
-		var kb float64
-		_, err := fmt.Sscanf(rateStr, "%fk", &kb)
-		if err != nil {
-			return nil, fmt.Errorf("invalid rate format: %s", rateStr)
+	return func(urlStr string) string {
+		key := urlKey(urlStr)
+		if target, ok := redirects[key]; ok {
+			key = target
 		}
-		bytesPerSecond = kb * 1024
-	} else if strings.HasSuffix(rateStr, "m") {
-		// Parse megabytes per second
-		var mb float64
-		_, err := fmt.Sscanf(rateStr, "%fm", &mb)
-		if err != nil {
-			return nil, fmt.Errorf("invalid rate format: %s", rateStr)
+		return saved[key]
+	}
+}
+
+// existingLocalPath finds a copy of urlStr saved by an earlier run, returning
+// a nil FileInfo when there is none. With --adjust-extension the file may carry
+// an added .html or .css suffix, and a URL without an extension may have been
+// saved with one matching its content type.
+func existingLocalPath(urlStr string, options *Options) (string, os.FileInfo) {
+	localPath := GetLocalFilePath(urlStr, options.OutputPath, options.SpanHosts)
+	if localPath == "" {
+		return "", nil
+	}
+
+	candidates := []string{localPath}
+	if !hasExtension(urlStr) {
+		for _, ext := range typeExtensions {
+			candidates = append(candidates, localPath+ext)
 		}
-		bytesPerSecond = mb * 1024 * 1024
-	} else {
-		// Parse bytes per second
-		_, err := fmt.Sscanf(rateStr, "%f", &bytesPerSecond)
-		if err != nil {
-			return nil, fmt.Errorf("invalid rate format: %s", rateStr)
+	}
+	if options.AdjustExtension {
+		candidates = append(candidates, adjustExtension(localPath, "text/html"), adjustExtension(localPath, "text/css"))
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate, info
 		}
 	}
-	
-	if bytesPerSecond <= 0 {
-		return nil, fmt.Errorf("rate must be positive: %s", rateStr)
+	return "", nil
+}
+
+// typeExtensions are the extensions given to files saved from URLs without
+// one, by media type. Other types use the first extension the mime package
+// knows for them.
+var typeExtensions = map[string]string{
+	"application/javascript":   ".js",
+	"application/json":         ".json",
+	"application/pdf":          ".pdf",
+	"application/x-javascript": ".js",
+	"application/xml":          ".xml",
+	"application/zip":          ".zip",
+	"audio/mpeg":               ".mp3",
+	"font/otf":                 ".otf",
+	"font/ttf":                 ".ttf",
+	"font/woff":                ".woff",
+	"font/woff2":               ".woff2",
+	"image/avif":               ".avif",
+	"image/gif":                ".gif",
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/svg+xml":            ".svg",
+	"image/vnd.microsoft.icon": ".ico",
+	"image/webp":               ".webp",
+	"image/x-icon":             ".ico",
+	"text/javascript":          ".js",
+	"text/plain":               ".txt",
+	"text/xml":                 ".xml",
+	"video/mp4":                ".mp4",
+}
+
+// typeExtension returns the extension to add to the file saved for urlStr when
+// its path has none, chosen by the response's content type. HTML and CSS keep
+// their names unless --adjust-extension is set, and generic binary responses
+// get no extension.
+func typeExtension(urlStr, contentType string) string {
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil || path.Ext(parsedURL.Path) != "" || strings.HasSuffix(parsedURL.Path, "/") {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "text/css", "application/octet-stream":
+		return ""
+	}
+	if ext, ok := typeExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// adjustExtension appends .html to HTML responses and .css to stylesheets
+// whose local file name doesn't already end that way
+func adjustExtension(localPath, contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	ext := strings.ToLower(filepath.Ext(localPath))
+	switch mediaType {
+	case "text/html", "application/xhtml+xml":
+		if ext != ".html" && ext != ".htm" {
+			return localPath + ".html"
+		}
+	case "text/css":
+		if ext != ".css" {
+			return localPath + ".css"
+		}
 	}
-	
-	// Create rate limiter (assuming average request size of 1KB for simplicity)
-	requestsPerSecond := bytesPerSecond / 1024
-	return rate.NewLimiter(rate.Limit(requestsPerSecond), 1), nil
+	return localPath
 }
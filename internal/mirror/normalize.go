@@ -0,0 +1,53 @@
+package mirror
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// repeatedSlashes matches runs of slashes in a URL path
+var repeatedSlashes = regexp.MustCompile(`/{2,}`)
+
+// defaultPorts are the ports implied by each scheme
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// cleanURL returns the form of urlStr that is queued and fetched: without a
+// fragment, with repeated slashes in the path collapsed, and with the scheme
+// and host lowercased and a default port dropped. URLs that don't parse are
+// returned unchanged.
+func cleanURL(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return urlStr
+	}
+	u.Fragment, u.RawFragment = "", ""
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); port != "" && port == defaultPorts[u.Scheme] {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+
+	escaped := repeatedSlashes.ReplaceAllString(u.EscapedPath(), "/")
+	if unescaped, err := url.PathUnescape(escaped); err == nil {
+		u.Path, u.RawPath = unescaped, escaped
+	}
+	if u.Path == "" && u.Host != "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
+
+// urlKey returns the key under which a URL is marked visited. URLs that differ
+// only by a trailing slash, a fragment or the details cleanURL normalizes
+// share a key, so only the first of them to be queued is fetched.
+func urlKey(urlStr string) string {
+	cleaned := cleanURL(urlStr)
+	u, err := url.Parse(cleaned)
+	if err != nil || u.Path == "/" || !strings.HasSuffix(u.Path, "/") {
+		return cleaned
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+	return u.String()
+}
@@ -2,9 +2,14 @@ package mirror
 
 import (
 	"fmt"
+	"io"
+	"mime"
 	"net/url"
+	"path"
 	"regexp"
 	"strings"
+
+	"golang.org/x/net/html"
 )
 
 // ResourceType represents different types of web resources
@@ -22,127 +27,441 @@ const (
 type Resource struct {
 	URL      string
 	Type     ResourceType
-	Original string // Original text in the document
+	Original string // Original text of the reference in the document
+	Offset   int    // Byte offset of Original in the parsed content
+	// Requisite is set for assets needed to display the page, such as images,
+	// stylesheets and scripts, as opposed to links to other documents
+	Requisite bool
 }
 
-// ParseHTML extracts all resources (links, images, CSS, JS) from HTML content
+// ParseHTML extracts all resources (links, images, CSS, JS) from HTML content.
+// It walks the document with an HTML tokenizer so attribute order, quoting and
+// line breaks inside tags don't matter, and text inside <script> is ignored.
+// Relative URLs are resolved against the first <base href> if the document
+// declares one, otherwise against baseURL. CSS inside <style> blocks and
+// style attributes is scanned with ParseCSS.
 func ParseHTML(content string, baseURL *url.URL) ([]Resource, error) {
 	var resources []Resource
+	seenBase := false
+
+	// Track where each token starts so references can be rewritten in place
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	tokenStart, tokenEnd := 0, 0
+	next := func() html.TokenType {
+		tokenType := tokenizer.Next()
+		tokenStart, tokenEnd = tokenEnd, tokenEnd+len(tokenizer.Raw())
+		return tokenType
+	}
 
-	// Extract links (href attributes)
-	hrefRegex := regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
-	hrefMatches := hrefRegex.FindAllStringSubmatch(content, -1)
-	for _, match := range hrefMatches {
-		if len(match) > 1 {
-			absURL, err := resolveURL(match[1], baseURL)
-			if err == nil {
-				resType := determineResourceType(absURL)
+	for {
+		tokenType := next()
+		switch tokenType {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return resources, err
+			}
+			return resources, nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			t := parseTag(string(name), content[tokenStart:tokenEnd], tokenStart)
+			if t.name == "base" && !seenBase {
+				if href := strings.TrimSpace(t.get("href")); href != "" {
+					seenBase = true
+					if parsedHref, err := url.Parse(href); err == nil {
+						baseURL = baseURL.ResolveReference(parsedHref)
+					}
+				}
+				continue
+			}
+			if style, ok := t.attrs["style"]; ok {
+				resources = append(resources, parseEmbeddedCSS(content, style.start, style.end, baseURL, true)...)
+			}
+			if t.name == "style" && tokenType == html.StartTagToken {
+				// The tokenizer returns the contents of <style> as a single text token
+				if next() == html.TextToken {
+					resources = append(resources, parseEmbeddedCSS(content, tokenStart, tokenEnd, baseURL, false)...)
+				}
+			}
+			requisite := isRequisite(t)
+			for _, ref := range elementReferences(t) {
+				start, end := trimSpan(content, ref.start, ref.end)
+				if start == end {
+					continue
+				}
+				original := content[start:end]
+				absURL, err := resolveURL(html.UnescapeString(original), baseURL)
+				if err != nil {
+					continue
+				}
+				resType := ref.resType
+				if resType < 0 {
+					resType = determineResourceType(absURL, "")
+				}
 				resources = append(resources, Resource{
-					URL:      absURL,
-					Type:     resType,
-					Original: match[0],
+					URL:       absURL,
+					Type:      resType,
+					Original:  original,
+					Offset:    start,
+					Requisite: requisite,
 				})
 			}
 		}
 	}
+}
 
-	// Extract images (src attributes)
-	srcRegex := regexp.MustCompile(`(?i)src\s*=\s*["']([^"']+)["']`)
-	srcMatches := srcRegex.FindAllStringSubmatch(content, -1)
-	for _, match := range srcMatches {
-		if len(match) > 1 {
-			absURL, err := resolveURL(match[1], baseURL)
-			if err == nil {
-				resources = append(resources, Resource{
-					URL:      absURL,
-					Type:     Image,
-					Original: match[0],
-				})
+// parseEmbeddedCSS parses the CSS in content[start:end] and shifts the
+// offsets of the resources it finds to positions in content. CSS in an
+// attribute may contain character references, unlike the raw text of <style>.
+func parseEmbeddedCSS(content string, start, end int, baseURL *url.URL, inAttribute bool) []Resource {
+	resources := parseCSS(content[start:end], baseURL, inAttribute)
+	for i := range resources {
+		resources[i].Offset += start
+	}
+	return resources
+}
+
+// tag is a start tag with its attributes. Each attribute keeps the location of
+// its raw value in the document so references can be rewritten in place.
+type tag struct {
+	name  string
+	attrs map[string]tagAttribute
+}
+
+type tagAttribute struct {
+	val        string // Value with character references decoded
+	start, end int    // Span of the raw value in the document
+}
+
+// get returns the value of the named attribute, or "" if absent
+func (t tag) get(name string) string {
+	return t.attrs[name].val
+}
+
+// parseTag reads the attributes of a raw start tag found at offset in the
+// document. As in HTML, the first of several same-named attributes wins.
+func parseTag(name, raw string, offset int) tag {
+	t := tag{name: name, attrs: make(map[string]tagAttribute)}
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+	}
+
+	// Skip "<" and the tag name
+	i := 1
+	for i < len(raw) && !isSpace(raw[i]) && raw[i] != '>' && raw[i] != '/' {
+		i++
+	}
+
+	for i < len(raw) {
+		for i < len(raw) && (isSpace(raw[i]) || raw[i] == '/') {
+			i++
+		}
+		if i >= len(raw) || raw[i] == '>' {
+			break
+		}
+
+		nameStart := i
+		for i < len(raw) && !isSpace(raw[i]) && raw[i] != '=' && raw[i] != '>' && raw[i] != '/' {
+			i++
+		}
+		if i == nameStart {
+			// A stray "=" where a name should be
+			i++
+			continue
+		}
+		attrName := strings.ToLower(raw[nameStart:i])
+
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		valStart, valEnd := i, i
+		if i < len(raw) && raw[i] == '=' {
+			i++
+			for i < len(raw) && isSpace(raw[i]) {
+				i++
+			}
+			if i < len(raw) && (raw[i] == '"' || raw[i] == '\'') {
+				quote := raw[i]
+				i++
+				valStart = i
+				if j := strings.IndexByte(raw[i:], quote); j >= 0 {
+					i += j
+				} else {
+					i = len(raw)
+				}
+				valEnd = i
+				i++
+			} else {
+				valStart = i
+				for i < len(raw) && !isSpace(raw[i]) && raw[i] != '>' {
+					i++
+				}
+				valEnd = i
+			}
+		}
+
+		if _, seen := t.attrs[attrName]; !seen {
+			t.attrs[attrName] = tagAttribute{
+				val:   html.UnescapeString(raw[valStart:valEnd]),
+				start: offset + valStart,
+				end:   offset + valEnd,
 			}
 		}
 	}
 
-	// Extract CSS imports and links
-	cssLinkRegex := regexp.MustCompile(`(?i)<link[^>]*rel\s*=\s*["']stylesheet["'][^>]*href\s*=\s*["']([^"']+)["']`)
-	cssMatches := cssLinkRegex.FindAllStringSubmatch(content, -1)
-	for _, match := range cssMatches {
-		if len(match) > 1 {
-			absURL, err := resolveURL(match[1], baseURL)
-			if err == nil {
-				resources = append(resources, Resource{
-					URL:      absURL,
-					Type:     CSS,
-					Original: match[0],
-				})
+	return t
+}
+
+// trimSpan narrows content[start:end] to exclude surrounding whitespace
+func trimSpan(content string, start, end int) (int, int) {
+	for start < end && strings.ContainsRune(" \t\n\r\f", rune(content[start])) {
+		start++
+	}
+	for end > start && strings.ContainsRune(" \t\n\r\f", rune(content[end-1])) {
+		end--
+	}
+	return start, end
+}
+
+// elementReference is the location of a URL in an element's attributes. A
+// negative resType means the type should be guessed from the URL.
+type elementReference struct {
+	start, end int
+	resType    ResourceType
+}
+
+const guessType ResourceType = -1
+
+// elementReferences returns the URL-valued attributes of an HTML element
+func elementReferences(t tag) []elementReference {
+	var refs []elementReference
+	add := func(name string, resType ResourceType) {
+		if attr, ok := t.attrs[name]; ok {
+			refs = append(refs, elementReference{start: attr.start, end: attr.end, resType: resType})
+		}
+	}
+	addSrcset := func() {
+		if attr, ok := t.attrs["srcset"]; ok {
+			for _, span := range srcsetSpans(attr.val, attr.start, attr.end) {
+				refs = append(refs, elementReference{start: span[0], end: span[1], resType: Image})
 			}
 		}
 	}
 
-	// Extract JavaScript files
-	jsRegex := regexp.MustCompile(`(?i)<script[^>]*src\s*=\s*["']([^"']+)["']`)
-	jsMatches := jsRegex.FindAllStringSubmatch(content, -1)
-	for _, match := range jsMatches {
-		if len(match) > 1 {
-			absURL, err := resolveURL(match[1], baseURL)
-			if err == nil {
-				resources = append(resources, Resource{
-					URL:      absURL,
-					Type:     JS,
-					Original: match[0],
-				})
+	switch t.name {
+	case "a", "area":
+		add("href", guessType)
+	case "link":
+		if strings.Contains(strings.ToLower(t.get("rel")), "stylesheet") {
+			add("href", CSS)
+		} else {
+			add("href", guessType)
+		}
+	case "script":
+		add("src", JS)
+	case "img":
+		add("src", Image)
+		addSrcset()
+	case "input":
+		if strings.EqualFold(t.get("type"), "image") {
+			add("src", Image)
+		}
+	case "iframe", "frame":
+		add("src", HTML)
+	case "source":
+		// <source> appears inside <picture>, <video> and <audio>
+		add("src", guessType)
+		addSrcset()
+	case "embed", "video", "audio", "track":
+		add("src", guessType)
+	case "meta":
+		if strings.EqualFold(t.get("http-equiv"), "refresh") {
+			if attr, ok := t.attrs["content"]; ok {
+				if start, end, found := metaRefreshSpan(attr.val); found && attr.end-attr.start == len(attr.val) {
+					refs = append(refs, elementReference{start: attr.start + start, end: attr.start + end, resType: HTML})
+				}
 			}
 		}
 	}
 
-	return resources, nil
+	return refs
+}
+
+// isRequisite reports whether the references of an element are assets of the
+// page rather than links to other documents. <link> only counts for the
+// relations a browser loads while rendering.
+func isRequisite(t tag) bool {
+	switch t.name {
+	case "a", "area", "meta":
+		return false
+	case "link":
+		for _, rel := range strings.Fields(strings.ToLower(t.get("rel"))) {
+			switch rel {
+			case "stylesheet", "icon", "apple-touch-icon", "preload", "modulepreload", "manifest":
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// srcsetSpans locates the candidate URLs of a srcset value such as
+// "a.jpg 1x, b.jpg 480w", dropping the width or density descriptors. The
+// spans are offsets into the document, where the raw value occupies
+// [start, end). Values containing character references are skipped because
+// their decoded positions don't line up with the raw text.
+func srcsetSpans(srcset string, start, end int) [][2]int {
+	if end-start != len(srcset) {
+		return nil
+	}
+
+	var spans [][2]int
+	pos := 0
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) > 0 {
+			i := pos + strings.Index(candidate, fields[0])
+			spans = append(spans, [2]int{start + i, start + i + len(fields[0])})
+		}
+		pos += len(candidate) + 1
+	}
+	return spans
+}
+
+// metaRefreshSpan locates the target of a meta refresh such as "0;url=/new"
+// or "5; URL='page.html'" and returns its start and end within content
+func metaRefreshSpan(content string) (int, int, bool) {
+	i := strings.IndexAny(content, ";,")
+	if i < 0 {
+		return 0, 0, false
+	}
+	start, end := trimSpan(content, i+1, len(content))
+	if end-start >= 3 && strings.EqualFold(content[start:start+3], "url") {
+		j, _ := trimSpan(content, start+3, end)
+		if j < end && content[j] == '=' {
+			start, end = trimSpan(content, j+1, end)
+		}
+	}
+	for start < end && (content[start] == '"' || content[start] == '\'') {
+		start++
+	}
+	for end > start && (content[end-1] == '"' || content[end-1] == '\'') {
+		end--
+	}
+	return start, end, start < end
 }
 
 // ParseCSS extracts URLs from CSS content (imports, background images, etc.)
 func ParseCSS(content string, baseURL *url.URL) ([]Resource, error) {
+	return parseCSS(content, baseURL, false), nil
+}
+
+// parseCSS extracts URLs from CSS. With inAttribute the CSS is the raw value of
+// a style attribute, so quotes may be written as &quot; and URLs are decoded
+// before they're resolved.
+func parseCSS(content string, baseURL *url.URL, inAttribute bool) []Resource {
 	var resources []Resource
+	add := func(start, end int, resType ResourceType) {
+		start, end = trimSpan(content, start, end)
+		if inAttribute {
+			start, end = trimQuoteReferences(content, start, end)
+		}
+		if start == end {
+			return
+		}
+		href := content[start:end]
+		if inAttribute {
+			href = html.UnescapeString(href)
+		}
+		absURL, err := resolveURL(href, baseURL)
+		if err != nil {
+			return
+		}
+		if resType < 0 {
+			resType = determineResourceType(absURL, "")
+		}
+		resources = append(resources, Resource{
+			URL:       absURL,
+			Type:      resType,
+			Original:  content[start:end],
+			Offset:    start,
+			Requisite: true,
+		})
+	}
 
 	// Extract @import statements
-	importRegex := regexp.MustCompile(`(?i)@import\s+["']([^"']+)["']`)
-	importMatches := importRegex.FindAllStringSubmatch(content, -1)
-	for _, match := range importMatches {
-		if len(match) > 1 {
-			absURL, err := resolveURL(match[1], baseURL)
-			if err == nil {
-				resources = append(resources, Resource{
-					URL:      absURL,
-					Type:     CSS,
-					Original: match[0],
-				})
+	for _, match := range cssImportRegex.FindAllStringSubmatchIndex(content, -1) {
+		add(match[2], match[3], CSS)
+	}
+
+	// Extract url() references (background images, fonts, etc.). Quoted
+	// values are matched whole, so url() text inside an inline SVG data URI
+	// isn't mistaken for a reference of the stylesheet.
+	for _, match := range cssURLRegex.FindAllStringSubmatchIndex(content, -1) {
+		for group := 2; group < len(match); group += 2 {
+			if match[group] >= 0 {
+				add(match[group], match[group+1], guessType)
+				break
 			}
 		}
 	}
 
-	// Extract url() references (background images, fonts, etc.)
-	urlRegex := regexp.MustCompile(`(?i)url\s*\(\s*["']?([^"')]+)["']?\s*\)`)
-	urlMatches := urlRegex.FindAllStringSubmatch(content, -1)
-	for _, match := range urlMatches {
-		if len(match) > 1 {
-			absURL, err := resolveURL(match[1], baseURL)
-			if err == nil {
-				resType := determineResourceType(absURL)
-				resources = append(resources, Resource{
-					URL:      absURL,
-					Type:     resType,
-					Original: match[0],
-				})
-			}
+	return resources
+}
+
+// quoteReferences are the character references that stand for quotes in an
+// attribute value
+var quoteReferences = []string{"&quot;", "&#34;", "&#x22;", "&apos;", "&#39;", "&#x27;"}
+
+// trimQuoteReferences narrows content[start:end] to exclude quotes written as
+// character references at either end
+func trimQuoteReferences(content string, start, end int) (int, int) {
+	for _, ref := range quoteReferences {
+		if strings.HasPrefix(content[start:end], ref) {
+			start += len(ref)
+			break
 		}
 	}
+	for _, ref := range quoteReferences {
+		if strings.HasSuffix(content[start:end], ref) {
+			end -= len(ref)
+			break
+		}
+	}
+	return start, end
+}
+
+var (
+	cssImportRegex = regexp.MustCompile(`(?i)@import\s+["']([^"']+)["']`)
+	cssURLRegex    = regexp.MustCompile(`(?i)url\s*\(\s*(?:"([^"]*)"|'([^']*)'|([^"')]+))\s*\)`)
+)
 
-	return resources, nil
+// inlineSchemes are schemes of references that carry their content inline or
+// aren't fetched over the network
+var inlineSchemes = []string{"data:", "javascript:", "mailto:", "tel:"}
+
+// skipReference reports whether href points at nothing to download: an empty
+// reference, a fragment of the same document, or an inline or non-network
+// URL such as data:, javascript: or mailto:
+func skipReference(href string) bool {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return true
+	}
+	lower := strings.ToLower(href)
+	for _, scheme := range inlineSchemes {
+		if strings.HasPrefix(lower, scheme) {
+			return true
+		}
+	}
+	return false
 }
 
-// resolveURL converts a relative URL to an absolute URL
+// resolveURL converts a relative URL to an absolute URL. References that
+// skipReference rejects are returned as errors.
 func resolveURL(href string, baseURL *url.URL) (string, error) {
-	// Skip data URLs, javascript:, mailto:, etc.
-	if strings.HasPrefix(href, "data:") || strings.HasPrefix(href, "javascript:") ||
-		strings.HasPrefix(href, "mailto:") || strings.HasPrefix(href, "tel:") {
-		return "", fmt.Errorf("skipping non-http URL: %s", href)
+	if skipReference(href) {
+		return "", fmt.Errorf("skipping reference without a resource: %.40q", href)
 	}
 
 	// Parse the href
@@ -151,61 +470,83 @@ func resolveURL(href string, baseURL *url.URL) (string, error) {
 		return "", err
 	}
 
+	// Protocol-relative URLs (//host/path) use the scheme of the page
+	if parsedHref.Scheme == "" && parsedHref.Host != "" {
+		parsedHref.Scheme = baseURL.Scheme
+	}
+
 	// Resolve relative to base URL
 	resolvedURL := baseURL.ResolveReference(parsedHref)
+	if resolvedURL.Scheme != "http" && resolvedURL.Scheme != "https" {
+		return "", fmt.Errorf("skipping non-http URL: %s", href)
+	}
 	return resolvedURL.String(), nil
 }
 
-// determineResourceType determines the type of resource based on URL
-func determineResourceType(urlStr string) ResourceType {
-	lower := strings.ToLower(urlStr)
+// determineResourceType classifies a resource by the media type of its
+// Content-Type when one is known, otherwise by the extension of its URL's
+// path. A path without an extension is assumed to be an HTML page.
+func determineResourceType(urlStr, contentType string) ResourceType {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType != "application/octet-stream" {
+		switch {
+		case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+			return HTML
+		case mediaType == "text/css":
+			return CSS
+		case strings.Contains(mediaType, "javascript") || strings.Contains(mediaType, "ecmascript"):
+			return JS
+		case strings.HasPrefix(mediaType, "image/"):
+			return Image
+		}
+		return Other
+	}
 
-	// Check file extension
-	if strings.Contains(lower, ".css") {
-		return CSS
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return Other
 	}
-	if strings.Contains(lower, ".js") {
+	switch strings.ToLower(path.Ext(parsedURL.Path)) {
+	case ".html", ".htm", ".xhtml", "":
+		return HTML
+	case ".css":
+		return CSS
+	case ".js", ".mjs":
 		return JS
-	}
-	if strings.Contains(lower, ".png") || strings.Contains(lower, ".jpg") ||
-		strings.Contains(lower, ".jpeg") || strings.Contains(lower, ".gif") ||
-		strings.Contains(lower, ".svg") || strings.Contains(lower, ".webp") {
+	case ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".ico":
 		return Image
 	}
-	if strings.Contains(lower, ".html") || strings.Contains(lower, ".htm") ||
-		!strings.Contains(lower, ".") { // Assume URLs without extensions are HTML
-		return HTML
-	}
-
 	return Other
 }
 
-// FilterResources filters resources based on reject and exclude patterns
-func FilterResources(resources []Resource, rejectTypes []string, excludeDirs []string) []Resource {
+// FilterResources filters resources based on reject types and included and
+// excluded directories. Include is applied before exclude. When acceptTypes is
+// non-empty, only resources with an accepted extension are kept, except HTML
+// pages which are still needed to continue crawling and URLs without an
+// extension, which are judged by their content type once fetched.
+func FilterResources(resources []Resource, rejectTypes []string, includeDirs []string, excludeDirs []string, acceptTypes []string) []Resource {
 	var filtered []Resource
 
 	for _, resource := range resources {
-		// Check reject patterns (file types)
-		rejected := false
-		for _, reject := range rejectTypes {
-			if strings.Contains(strings.ToLower(resource.URL), strings.ToLower(reject)) {
-				rejected = true
-				break
-			}
+		// Check reject list (file types), matching only the path's extension
+		// so query strings like "?v=1.2.css" don't count
+		if MatchesExtension(resource.URL, rejectTypes) {
+			continue
 		}
-		if rejected {
+
+		// Check include list (directory trees)
+		if len(includeDirs) > 0 && !InDirectories(resource.URL, includeDirs) {
 			continue
 		}
 
-		// Check exclude patterns (directories)
-		excluded := false
-		for _, exclude := range excludeDirs {
-			if strings.Contains(resource.URL, exclude) {
-				excluded = true
-				break
-			}
+		// Check exclude list (directory trees)
+		if InDirectories(resource.URL, excludeDirs) {
+			continue
 		}
-		if excluded {
+
+		// Check accept list (file types). URLs without an extension are kept
+		// until their content type is known.
+		if len(acceptTypes) > 0 && resource.Type != HTML && !MatchesExtension(resource.URL, acceptTypes) && hasExtension(resource.URL) {
 			continue
 		}
 
@@ -214,3 +555,57 @@ func FilterResources(resources []Resource, rejectTypes []string, excludeDirs []s
 
 	return filtered
 }
+
+// MatchesExtension reports whether the file extension of the URL's path is one
+// of the given types. Types may be written as "jpg", ".jpg" or "*.jpg".
+func MatchesExtension(urlStr string, types []string) bool {
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return false
+	}
+
+	return matchesType(path.Ext(parsedURL.Path), types)
+}
+
+// hasExtension reports whether the URL's path ends in a file extension
+func hasExtension(urlStr string) bool {
+	parsedURL, err := url.Parse(urlStr)
+	return err == nil && path.Ext(parsedURL.Path) != ""
+}
+
+// matchesType reports whether the extension ext is one of the given types
+func matchesType(ext string, types []string) bool {
+	ext = strings.TrimPrefix(strings.ToLower(ext), ".")
+	if ext == "" {
+		return false
+	}
+
+	for _, t := range types {
+		t = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(t), "*"), ".")
+		if t == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// InDirectories reports whether the URL's path lies inside one of the given
+// directory trees. Directories are matched on whole path segments, so "/img"
+// covers "/img/a.png" but not "/imglib/a.png".
+func InDirectories(urlStr string, dirs []string) bool {
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return false
+	}
+
+	for _, dir := range dirs {
+		dir = "/" + strings.Trim(dir, "/")
+		if dir == "/" {
+			return true
+		}
+		if parsedURL.Path == dir || strings.HasPrefix(parsedURL.Path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
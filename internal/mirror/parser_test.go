@@ -0,0 +1,104 @@
+package mirror
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestResolveProtocolRelativeURL(t *testing.T) {
+	tests := []struct {
+		base     string
+		href     string
+		want     string
+		sameHost bool
+	}{
+		{"http://example.com/dir/page.html", "//cdn.example.net/a.js", "http://cdn.example.net/a.js", false},
+		{"https://example.com/dir/page.html", "//cdn.example.net/a.js", "https://cdn.example.net/a.js", false},
+		{"https://example.com/", "//fonts.example.net/css?family=Sans", "https://fonts.example.net/css?family=Sans", false},
+		{"http://example.com/dir/", "//example.com/img/logo.png", "http://example.com/img/logo.png", true},
+		{"https://example.com:8443/", "//example.com:8443/x", "https://example.com:8443/x", true},
+		{"https://example.com/", "//example.com:8443/x", "https://example.com:8443/x", false},
+		{"http://example.com/dir/page.html", "../a.js", "http://example.com/a.js", true},
+	}
+
+	for _, tt := range tests {
+		base, err := url.Parse(tt.base)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.base, err)
+		}
+		got, err := resolveURL(tt.href, base)
+		if err != nil {
+			t.Errorf("resolveURL(%q, %q) error: %v", tt.href, tt.base, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveURL(%q, %q) = %q, want %q", tt.href, tt.base, got, tt.want)
+		}
+
+		resolved, err := url.Parse(got)
+		if err != nil {
+			t.Fatalf("parse %q: %v", got, err)
+		}
+		filter := &HostFilter{BaseHost: base.Host}
+		if allowed := filter.Allows(resolved); allowed != tt.sameHost {
+			t.Errorf("same-host check for %q from %q = %v, want %v", tt.href, tt.base, allowed, tt.sameHost)
+		}
+	}
+}
+
+func TestDetermineResourceType(t *testing.T) {
+	tests := []struct {
+		url         string
+		contentType string
+		want        ResourceType
+	}{
+		// The Content-Type decides when the server sends one
+		{"http://example.com/about", "text/html; charset=utf-8", HTML},
+		{"http://example.com/about", "application/xhtml+xml", HTML},
+		{"http://example.com/theme", "text/css", CSS},
+		{"http://example.com/bundle", "text/javascript", JS},
+		{"http://example.com/logo", "image/png", Image},
+		{"http://example.com/download", "application/zip", Other},
+		{"http://example.com/report.html", "application/pdf", Other},
+		// Without one, or with a generic one, the path's extension decides
+		{"http://example.com/about", "", HTML},
+		{"http://example.com/docs/", "", HTML},
+		{"http://example.com/page.htm?id=3", "", HTML},
+		{"http://example.com/page.html", "application/octet-stream", HTML},
+		{"http://example.com/app.js?v=1.css", "", JS},
+		{"http://example.com/img/photo.JPG", "", Image},
+		{"http://example.com/archive.tar.gz", "", Other},
+		// Host names and directories that look like extensions don't count
+		{"https://cdn.jsdelivr.net/npm/package", "", HTML},
+		{"https://cdn.jsdelivr.net/npm/package/dist/site.css", "", CSS},
+		{"http://assets.css.example.com/logo.png", "", Image},
+		{"http://example.com/scripts.js/readme", "", HTML},
+	}
+
+	for _, tt := range tests {
+		if got := determineResourceType(tt.url, tt.contentType); got != tt.want {
+			t.Errorf("determineResourceType(%q, %q) = %v, want %v", tt.url, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+// TestExtensionlessHTMLPageIsParsed checks that a page served as text/html
+// without an extension in its URL is treated as HTML, and that a link to a
+// host whose name contains ".js" is not mistaken for a script
+func TestExtensionlessHTMLPageIsParsed(t *testing.T) {
+	if !isHTML("http://example.com/about", "text/html; charset=utf-8") {
+		t.Error("extensionless text/html page is not HTML")
+	}
+	if isHTML("http://example.com/feed", "application/rss+xml") {
+		t.Error("RSS feed is HTML")
+	}
+
+	base, _ := url.Parse("http://example.com/")
+	resources, err := ParseHTML(`<a href="https://cdn.jsdelivr.net/npm/package">docs</a>`, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 || resources[0].Type != HTML {
+		t.Errorf("ParseHTML = %+v, want one HTML resource", resources)
+	}
+}
@@ -0,0 +1,104 @@
+package mirror
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxSegmentLength caps each file or directory name, leaving headroom
+	// below the common 255 byte limit
+	maxSegmentLength = 200
+	// maxQueryLength is the longest query string kept verbatim in a file name
+	maxQueryLength = 64
+)
+
+// windowsReservedNames can't be used as file names on Windows, with or
+// without an extension
+var windowsReservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// localPathSegments turns a decoded URL path and raw query into safe file
+// system names. "." and ".." segments are resolved so the result can't climb
+// out of the output directory, and a query is appended to the last segment so
+// "page?id=1" and "page?id=2" are saved separately.
+func localPathSegments(urlPath, rawQuery string) []string {
+	var segments []string
+	for _, segment := range strings.Split(urlPath, "/") {
+		switch segment {
+		case "", ".":
+		case "..":
+			if len(segments) > 0 {
+				segments = segments[:len(segments)-1]
+			}
+		default:
+			segments = append(segments, segment)
+		}
+	}
+
+	// Directories are saved as their index page
+	if len(segments) == 0 || strings.HasSuffix(urlPath, "/") {
+		segments = append(segments, "index.html")
+	}
+
+	if rawQuery != "" {
+		if len(rawQuery) > maxQueryLength {
+			rawQuery = shortHash(rawQuery)
+		}
+		segments[len(segments)-1] += "?" + rawQuery
+	}
+
+	for i, segment := range segments {
+		segments[i] = sanitizeSegment(segment)
+	}
+	return segments
+}
+
+// sanitizeSegment makes a single path segment valid on common file systems.
+// Characters that are illegal on Windows or unprintable are percent-encoded,
+// reserved device names get an underscore prefix and overly long names are
+// shortened with a hash so they stay unique.
+func sanitizeSegment(segment string) string {
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte(`\:*?"<>|%`, c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	name := b.String()
+
+	// Windows drops trailing dots and spaces
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		name += "_"
+	}
+
+	base := strings.ToLower(name)
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if windowsReservedNames[base] {
+		name = "_" + name
+	}
+
+	if len(name) > maxSegmentLength {
+		hash := shortHash(name)
+		name = strings.ToValidUTF8(name[:maxSegmentLength-len(hash)-1], "") + "-" + hash
+	}
+	return name
+}
+
+// shortHash returns a short, stable hex digest of s
+func shortHash(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,172 @@
+package mirror
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"wget/internal/httpclient"
+	"wget/internal/logging"
+	"wget/internal/ratelimit"
+)
+
+// sitemapPath is where a site's sitemap is looked for
+const sitemapPath = "/sitemap.xml"
+
+// maxSitemapSize bounds a single sitemap, matching the 50MB limit of the
+// sitemap protocol
+const maxSitemapSize = 50 << 20
+
+// sitemap holds either form of sitemap document: a <urlset> listing pages or
+// a <sitemapindex> listing further sitemaps
+type sitemap struct {
+	XMLName xml.Name
+	URLs    []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// ParseSitemap returns the page URLs listed in a sitemap and the nested
+// sitemaps listed in a sitemap index. Gzipped sitemaps are decompressed.
+func ParseSitemap(content []byte) (pages, sitemaps []string, err error) {
+	if len(content) > 2 && content[0] == 0x1f && content[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzipped sitemap: %v", err)
+		}
+		content, err = io.ReadAll(io.LimitReader(gz, maxSitemapSize))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzipped sitemap: %v", err)
+		}
+	}
+
+	var doc sitemap
+	if err := xml.Unmarshal(content, &doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid sitemap: %v", err)
+	}
+	switch doc.XMLName.Local {
+	case "urlset":
+		for _, u := range doc.URLs {
+			pages = append(pages, u.Loc)
+		}
+	case "sitemapindex":
+		for _, sm := range doc.Sitemaps {
+			sitemaps = append(sitemaps, sm.Loc)
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid sitemap: unexpected <%s> element", doc.XMLName.Local)
+	}
+	return pages, sitemaps, nil
+}
+
+// seedFromSitemap queues the pages listed in the site's sitemap, following a
+// sitemap index one level down, so pages no link points to are still
+// mirrored. Listed URLs pass the same filters as links found in pages.
+func (s *MirrorState) seedFromSitemap(ctx context.Context, options *Options) {
+	sitemapURL := (&url.URL{Scheme: s.baseURL.Scheme, Host: s.baseURL.Host, Path: sitemapPath}).String()
+	pages, nested, err := s.fetchSitemap(ctx, sitemapURL, options)
+	if err != nil {
+		s.logger.Printf("Warning: failed to read sitemap %s: %v\n", logging.RedactURL(sitemapURL), err)
+		return
+	}
+	queued := s.queueSitemapPages(pages, sitemapURL, options)
+
+	for _, loc := range nested {
+		nestedURL, err := resolveURL(loc, s.baseURL)
+		if err != nil {
+			continue
+		}
+		parsed, err := url.Parse(nestedURL)
+		if err != nil || !s.hosts.Allows(parsed) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Indexes nested deeper than one level are not followed
+		pages, _, err := s.fetchSitemap(ctx, nestedURL, options)
+		if err != nil {
+			s.logger.Printf("Warning: failed to read sitemap %s: %v\n", logging.RedactURL(nestedURL), err)
+			continue
+		}
+		queued += s.queueSitemapPages(pages, nestedURL, options)
+	}
+
+	s.logger.Printf("Queued %d URLs from sitemap %s\n", queued, logging.RedactURL(sitemapURL))
+}
+
+// fetchSitemap downloads and parses one sitemap
+func (s *MirrorState) fetchSitemap(ctx context.Context, sitemapURL string, options *Options) (pages, sitemaps []string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpclient.SetUserAgent(req, options.UserAgent)
+	httpclient.SetHeaders(req, options.Headers)
+	s.setBasicAuth(req, options)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, logging.RedactError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(ratelimit.NewReader(ctx, resp.Body, s.limiter), maxSitemapSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	return ParseSitemap(content)
+}
+
+// queueSitemapPages adds the pages listed in a sitemap to the pending queue,
+// returning how many were new
+func (s *MirrorState) queueSitemapPages(pages []string, sitemapURL string, options *Options) int {
+	var resources []Resource
+	for _, loc := range pages {
+		absURL, err := resolveURL(loc, s.baseURL)
+		if err != nil {
+			continue
+		}
+		// Sitemaps list pages, so they stay crawlable under an accept list
+		resources = append(resources, Resource{URL: absURL, Type: HTML})
+	}
+	filtered := FilterResources(resources, options.RejectTypes, options.IncludeDirs, options.ExcludeDirs, options.AcceptTypes)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	queued := make(map[string]bool, len(s.pending))
+	for _, urlStr := range s.pending {
+		queued[urlKey(urlStr)] = true
+	}
+	count := 0
+	for _, resource := range filtered {
+		resURL, err := url.Parse(resource.URL)
+		if err != nil {
+			continue
+		}
+		if !s.hosts.Allows(resURL) || !s.withinStartDir(resURL, options) {
+			continue
+		}
+		resourceURL, key := cleanURL(resource.URL), urlKey(resource.URL)
+		if s.visited[key] || queued[key] {
+			continue
+		}
+		queued[key] = true
+		s.pending = append(s.pending, resourceURL)
+		s.recordReferrer(resourceURL, sitemapURL)
+		count++
+	}
+	return count
+}
@@ -0,0 +1,72 @@
+package mirror
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"wget/internal/logging"
+)
+
+// TestSpiderUsesHeadForUnparsedResources checks that spider mode sends GET
+// only for pages and stylesheets it parses for links, HEAD for the rest, and
+// falls back to GET when HEAD is refused or turns out to be a page
+func TestSpiderUsesHeadForUnparsedResources(t *testing.T) {
+	var mutex sync.Mutex
+	methods := make(map[string][]string)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<link rel="stylesheet" href="/style.css"><img src="/logo.png">` +
+			`<a href="/report.php">report</a><a href="/data.bin">data</a><a href="/missing.png">gone</a>`))
+	})
+	mux.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte(`body { background: url(/bg.gif) }`))
+	})
+	mux.HandleFunc("/report.php", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/deep.html">deep</a>`))
+	})
+	mux.HandleFunc("/data.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write([]byte("data"))
+	})
+	mux.HandleFunc("/missing.png", http.NotFound)
+	for _, name := range []string{"/logo.png", "/bg.gif", "/deep.html"} {
+		mux.HandleFunc(name, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		methods[r.URL.Path] = append(methods[r.URL.Path], r.Method)
+		mutex.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	options := &Options{OutputPath: t.TempDir(), Spider: true}
+	if err := MirrorWebsite(context.Background(), server.URL+"/", options, logging.NewDiscardLogger()); err == nil {
+		t.Error("spider found no broken links")
+	}
+
+	want := map[string][]string{
+		"/":            {"GET"},
+		"/style.css":   {"GET"},
+		"/logo.png":    {"HEAD"},
+		"/bg.gif":      {"HEAD"},
+		"/report.php":  {"HEAD", "GET"},
+		"/deep.html":   {"GET"},
+		"/data.bin":    {"HEAD", "GET"},
+		"/missing.png": {"HEAD"},
+	}
+	if !reflect.DeepEqual(methods, want) {
+		t.Errorf("request methods = %v, want %v", methods, want)
+	}
+}
@@ -0,0 +1,180 @@
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// StateFileName is the file in the output directory that records unfinished
+// crawls, so an interrupted mirror can pick up where it stopped
+const StateFileName = ".wget-mirror-state.json"
+
+// stateSaveInterval is how often the crawl state is written while mirroring,
+// bounding the work lost to a crash
+const stateSaveInterval = 10 * time.Second
+
+// savedCrawl is the resumable part of a MirrorState. The state file maps each
+// start URL to its crawl, so mirrors sharing an output directory don't collide.
+type savedCrawl struct {
+	BaseURL    string            `json:"base_url"` // Differs from the start URL after a redirect
+	Depth      int               `json:"depth"`
+	Current    []string          `json:"current"` // URLs left at Depth
+	Next       []string          `json:"next"`    // URLs found for Depth+1
+	Visited    []string          `json:"visited"`
+	Downloaded map[string]string `json:"downloaded"`
+	Redirects  map[string]string `json:"redirects"`
+	Requisites []string          `json:"requisites"`
+	Referrers  map[string]string `json:"referrers"`
+	Broken     []brokenLink      `json:"broken"`
+	FileCount  int               `json:"file_count"`
+	Bytes      int64             `json:"bytes"`
+}
+
+// statePath returns the location of the state file
+func statePath(options *Options) string {
+	return filepath.Join(options.OutputPath, StateFileName)
+}
+
+// readStateFile returns every crawl recorded in the state file. A missing
+// file holds no crawls.
+func readStateFile(path string) (map[string]*savedCrawl, error) {
+	crawls := make(map[string]*savedCrawl)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return crawls, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &crawls); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+	return crawls, nil
+}
+
+// writeStateFile replaces the state file with crawls, removing it when none
+// are left. The file is written under a temporary name and renamed so a crash
+// can't leave it half written.
+func writeStateFile(path string, crawls map[string]*savedCrawl) error {
+	if len(crawls) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(crawls, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// loadState restores the crawl of startURL saved by an earlier run, reporting
+// whether there was one
+func (s *MirrorState) loadState(startURL string, options *Options) (bool, error) {
+	crawls, err := readStateFile(statePath(options))
+	if err != nil {
+		return false, err
+	}
+	saved, ok := crawls[startURL]
+	if !ok {
+		return false, nil
+	}
+
+	baseURL, err := url.Parse(saved.BaseURL)
+	if err != nil {
+		return false, fmt.Errorf("invalid base URL in state file: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.baseURL = baseURL
+	s.hosts.BaseHost = baseURL.Host
+	s.depth = saved.Depth
+	s.pending = saved.Current
+	s.resumed = saved.Next
+	for _, urlStr := range saved.Visited {
+		s.visited[urlKey(urlStr)] = true
+	}
+	for urlStr, localPath := range saved.Downloaded {
+		s.downloaded[urlStr] = localPath
+	}
+	for urlStr, target := range saved.Redirects {
+		s.redirects[urlStr] = target
+	}
+	for _, urlStr := range saved.Requisites {
+		s.requisites[urlStr] = true
+	}
+	for urlStr, pageURL := range saved.Referrers {
+		s.referrers[urlStr] = pageURL
+	}
+	s.brokenLinks = saved.Broken
+	s.fileCount = saved.FileCount
+	s.bytes = saved.Bytes
+	return true, nil
+}
+
+// saveState records the crawl so far under startURL
+func (s *MirrorState) saveState(startURL string, options *Options) error {
+	path := statePath(options)
+	crawls, err := readStateFile(path)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.RLock()
+	saved := &savedCrawl{
+		BaseURL:    s.baseURL.String(),
+		Depth:      s.depth,
+		Current:    s.current,
+		Next:       s.pending,
+		Visited:    sortedKeys(s.visited),
+		Downloaded: s.downloaded,
+		Redirects:  s.redirects,
+		Requisites: sortedKeys(s.requisites),
+		Referrers:  s.referrers,
+		Broken:     s.brokenLinks,
+		FileCount:  s.fileCount,
+		Bytes:      s.bytes,
+	}
+	crawls[startURL] = saved
+	err = writeStateFile(path, crawls)
+	s.mutex.RUnlock()
+
+	s.lastSave = time.Now()
+	return err
+}
+
+// clearState forgets the crawl of startURL once it has finished
+func (s *MirrorState) clearState(startURL string, options *Options) error {
+	path := statePath(options)
+	crawls, err := readStateFile(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := crawls[startURL]; !ok {
+		return nil
+	}
+	delete(crawls, startURL)
+	return writeStateFile(path, crawls)
+}
+
+// sortedKeys returns the keys of a set in order, keeping the state file stable
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
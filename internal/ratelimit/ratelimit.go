@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// byteUnits scale byte rates by powers of 1024, so "1m" is 1 MiB/s
+var byteUnits = map[string]float64{
+	"":   1,
+	"b":  1,
+	"k":  1 << 10,
+	"kb": 1 << 10,
+	"m":  1 << 20,
+	"mb": 1 << 20,
+	"g":  1 << 30,
+	"gb": 1 << 30,
+}
+
+// bitUnits scale bit rates by powers of 1000, the way bandwidth is
+// advertised, and convert them to bytes, so "8mbit" is 1,000,000 bytes/s
+var bitUnits = map[string]float64{
+	"bit":  1.0 / 8,
+	"kbit": 1e3 / 8,
+	"mbit": 1e6 / 8,
+	"gbit": 1e9 / 8,
+}
+
+// maxBurstSize caps the limiter burst, and so the largest throttled read.
+//
+// The burst trades smoothness against overshoot. Tokens are handed out a
+// burst at a time, so a tiny burst means many short reads and waits that make
+// fast transfers stutter, while a large one lets a transfer run up to a whole
+// burst ahead of the rate at the start and after a pause. One second of data,
+// up to 64KiB, keeps reads large enough to fill network buffers without
+// noticeably exceeding the limit.
+const maxBurstSize = 64 << 10
+
+// Parse converts a rate limit such as "400k", "2M" or "8mbit" into a limiter
+// with one token per byte. Units are case-insensitive. Read through a Reader
+// so reads fit in the limiter's burst.
+func Parse(rateStr string) (*rate.Limiter, error) {
+	bytesPerSecond, err := parseBytes(rateStr, "rate limit")
+	if err != nil {
+		return nil, err
+	}
+
+	burstSize := maxBurstSize
+	if bytesPerSecond < maxBurstSize {
+		burstSize = int(bytesPerSecond)
+	}
+	if burstSize < 1 { // Rates under a byte per second still need whole bytes
+		burstSize = 1
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burstSize), nil
+}
+
+// ParseSize converts a size such as "500m" or "2G" into bytes, with the
+// same units as Parse
+func ParseSize(sizeStr string) (int64, error) {
+	size, err := parseBytes(sizeStr, "size")
+	if err != nil {
+		return 0, err
+	}
+	return int64(size), nil
+}
+
+// parseBytes converts a number with an optional unit into bytes. what names
+// the value in error messages.
+func parseBytes(str, what string) (float64, error) {
+	str = strings.ToLower(strings.TrimSpace(str))
+
+	// Split the number from its unit
+	unitStart := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if unitStart < 0 {
+		unitStart = len(str)
+	}
+	number, unit := str[:unitStart], str[unitStart:]
+
+	multiplier, ok := byteUnits[unit]
+	if !ok {
+		multiplier, ok = bitUnits[unit]
+	}
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %s: %s", what, unit)
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", what, str)
+	}
+	bytes := value * multiplier
+	if bytes <= 0 {
+		return 0, fmt.Errorf("%s must be positive: %s", what, str)
+	}
+	return bytes, nil
+}
+
+// Reader consumes one limiter token per byte read. Readers sharing a limiter
+// stay under its rate together.
+type Reader struct {
+	ctx     context.Context // Cancelling it aborts a pending wait
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+// NewReader throttles reader to limiter. It returns reader itself when
+// limiter is nil.
+func NewReader(ctx context.Context, reader io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return reader
+	}
+	return &Reader{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+// Read implements io.Reader, waiting for the limiter after each read. Reads
+// are no larger than the limiter's burst, which WaitN can't exceed.
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
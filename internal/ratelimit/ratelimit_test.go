@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestParseBitUnits(t *testing.T) {
+	tests := []struct {
+		input string
+		want  rate.Limit
+	}{
+		{"1m", 1 << 20},
+		{"1mbit", 125000},
+		{"8mbit", 1000000},
+		{"8Mbit", 1000000},
+		{"1kbit", 125},
+		{"1KBIT", 125},
+		{"1gbit", 125000000},
+		{"800bit", 100},
+		{"1.5mbit", 187500},
+	}
+
+	for _, tt := range tests {
+		limiter, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.input, err)
+			continue
+		}
+		if got := limiter.Limit(); got != tt.want {
+			t.Errorf("Parse(%q) = %v bytes/s, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    rate.Limit
+		wantErr bool
+	}{
+		{input: "500", want: 500},
+		{input: "500b", want: 500},
+		{input: "500B", want: 500},
+		{input: "400k", want: 400 << 10},
+		{input: "400K", want: 400 << 10},
+		{input: "400kb", want: 400 << 10},
+		{input: "400KB", want: 400 << 10},
+		{input: "2m", want: 2 << 20},
+		{input: "2M", want: 2 << 20},
+		{input: "2mb", want: 2 << 20},
+		{input: "2MB", want: 2 << 20},
+		{input: "1g", want: 1 << 30},
+		{input: "1G", want: 1 << 30},
+		{input: "1gb", want: 1 << 30},
+		{input: "1GB", want: 1 << 30},
+		{input: "1.5k", want: 1536},
+		{input: " 10k ", want: 10 << 10},
+		{input: "", wantErr: true},
+		{input: "k", wantErr: true},
+		{input: "fast", wantErr: true},
+		{input: "10x", wantErr: true},
+		{input: "10kbps", wantErr: true},
+		{input: "1.2.3m", wantErr: true},
+		{input: "-5k", wantErr: true},
+		{input: "0", wantErr: true},
+		{input: "0k", wantErr: true},
+		{input: "0mbit", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		limiter, err := Parse(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", tt.input, limiter.Limit())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.input, err)
+			continue
+		}
+		if got := limiter.Limit(); got != tt.want {
+			t.Errorf("Parse(%q) = %v bytes/s, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseUnitSpellingsAgree(t *testing.T) {
+	for _, spellings := range [][]string{
+		{"2M", "2m", "2mb", "2MB", "2Mb"},
+		{"64k", "64K", "64kb", "64KB"},
+		{"3g", "3G", "3gb", "3GB"},
+	} {
+		first, err := Parse(spellings[0])
+		if err != nil {
+			t.Fatalf("Parse(%q) error: %v", spellings[0], err)
+		}
+		for _, spelling := range spellings[1:] {
+			limiter, err := Parse(spelling)
+			if err != nil {
+				t.Errorf("Parse(%q) error: %v", spelling, err)
+				continue
+			}
+			if limiter.Limit() != first.Limit() || limiter.Burst() != first.Burst() {
+				t.Errorf("Parse(%q) = %v/%d, Parse(%q) = %v/%d", spelling, limiter.Limit(), limiter.Burst(),
+					spellings[0], first.Limit(), first.Burst())
+			}
+		}
+	}
+}
+
+func TestParseBurstSize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"1", 1},
+		{"1bit", 1},
+		{"10k", 10 << 10},
+		{"64k", maxBurstSize},
+		{"10m", maxBurstSize},
+	}
+
+	for _, tt := range tests {
+		limiter, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.input, err)
+			continue
+		}
+		if got := limiter.Burst(); got != tt.want {
+			t.Errorf("Parse(%q) burst = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+// zeroReader is an endless source of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// TestReaderThroughput copies a few seconds of data through a throttled
+// Reader and checks the rate stays within 10% of the limit
+func TestReaderThroughput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes a few seconds")
+	}
+
+	limiter, err := Parse("256k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const limit = 256 << 10
+	const seconds = 3
+
+	start := time.Now()
+	reader := NewReader(context.Background(), io.LimitReader(zeroReader{}, limit*seconds), limiter)
+	written, err := io.Copy(io.Discard, reader)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != limit*seconds {
+		t.Fatalf("copied %d bytes, want %d", written, limit*seconds)
+	}
+
+	// The first burst is available immediately, the rest arrives at the limit
+	throughput := float64(written-int64(limiter.Burst())) / elapsed.Seconds()
+	if throughput < limit*0.9 || throughput > limit*1.1 {
+		t.Errorf("throughput %.0f bytes/s, want within 10%% of %d", throughput, limit)
+	}
+}
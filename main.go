@@ -1,28 +1,146 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 	"wget/internal/batch"
 	"wget/internal/bg"
 	"wget/internal/downloader"
+	"wget/internal/httpclient"
 	"wget/internal/logging"
 	"wget/internal/mirror"
+	"wget/internal/ratelimit"
+
+	"golang.org/x/term"
+)
+
+// Exit statuses, matching GNU wget
+const (
+	exitGeneric = 1 // Any other error
+	exitUsage   = 2 // Invalid command line
+	exitIO      = 3 // Failed to write a file
+	exitNetwork = 4 // Failed to reach the server
+	exitSSL     = 5 // TLS certificate verification failed
+	exitServer  = 8 // The server answered with an error status
+	exitSignal  = 130
 )
 
 type Config struct {
-	URL          string
-	OutputName   string
-	OutputPath   string
-	RateLimit    string
-	Background   bool
-	InputFile    string
-	Mirror       bool
-	Reject       string
-	Exclude      string
-	ConvertLinks bool
+	URL                string
+	URLs               []string // Every URL given on the command line, URL is the first
+	OutputName         string
+	OutputPath         string
+	RateLimit          string
+	Quota              string
+	Background         bool
+	Daemon             bool
+	InputFile          string
+	Concurrency        int
+	FailFast           bool
+	Mirror             bool
+	Accept             string
+	Reject             string
+	Include            string
+	Exclude            string
+	ConvertLinks       bool
+	SpanHosts          bool
+	Wait               float64
+	RandomWait         bool
+	Domains            string
+	NoParent           bool
+	Sitemap            bool
+	Continue           bool
+	NoClobber          bool
+	Backups            int
+	Timestamping       bool
+	PreserveTimestamp  bool
+	Tries              int
+	MaxRedirect        int
+	ConnectTimeout     float64
+	ReadTimeout        float64
+	UserAgent          string
+	Headers            headerList
+	User               string
+	Password           string
+	Netrc              bool
+	NetrcFile          string
+	Proxy              string
+	BindAddress        string
+	Inet4Only          bool
+	Inet6Only          bool
+	NoCheckCertificate bool
+	Certificate        string
+	PrivateKey         string
+	CACertificate      string
+	LoadCookies        string
+	SaveCookies        string
+	KeepSessionCookies bool
+	Checksum           string
+	Chunks             int
+	PostData           string
+	PostFile           string
+	IgnoreLength       bool
+	TrustServerNames   bool
+	AdjustExtension    bool
+	PageRequisites     bool
+	Level              string
+	MaxFiles           int
+	Spider             bool
+	ServerResponse     bool
+	SaveHeaders        saveHeadersMode
+	Progress           string
+	Quiet              bool
+	OutputFile         string
+}
+
+// headerList collects repeated --header flags
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// saveHeadersMode is the value of --save-headers. The flag can be given
+// without a value to save the headers inline.
+type saveHeadersMode string
+
+func (m *saveHeadersMode) String() string {
+	return string(*m)
+}
+
+func (m *saveHeadersMode) Set(value string) error {
+	switch value {
+	case "true", downloader.SaveHeadersInline:
+		*m = downloader.SaveHeadersInline
+	case downloader.SaveHeadersSidecar:
+		*m = downloader.SaveHeadersSidecar
+	case "false":
+		*m = ""
+	default:
+		return fmt.Errorf("must be %s or %s", downloader.SaveHeadersInline, downloader.SaveHeadersSidecar)
+	}
+	return nil
+}
+
+func (m *saveHeadersMode) IsBoolFlag() bool {
+	return true
 }
 
 func main() {
@@ -31,55 +149,426 @@ func main() {
 	// Define flags
 	flag.StringVar(&config.OutputName, "O", "", "Save file with different name")
 	flag.StringVar(&config.OutputPath, "P", "", "Save file to specific directory")
-	flag.StringVar(&config.RateLimit, "rate-limit", "", "Limit download rate (e.g., 400k, 2M)")
+	flag.StringVar(&config.RateLimit, "rate-limit", "", "Limit download rate in bytes (e.g., 400k, 2M) or bits (e.g., 8mbit)")
+	flag.StringVar(&config.RateLimit, "limit-rate", "", "Limit download rate in bytes (e.g., 400k, 2M) or bits (e.g., 8mbit)")
+	flag.StringVar(&config.Quota, "Q", "", "Stop after downloading SIZE in total (e.g., 500M, 2G), 0 or inf for no limit")
+	flag.StringVar(&config.Quota, "quota", "", "Stop after downloading SIZE in total (e.g., 500M, 2G), 0 or inf for no limit")
 	flag.BoolVar(&config.Background, "B", false, "Download in background")
-	flag.StringVar(&config.InputFile, "i", "", "Download URLs from file")
+	flag.BoolVar(&config.Daemon, "daemon", false, "Internal: run as the detached background process")
+	flag.StringVar(&config.InputFile, "i", "", "Download URLs from file, or from standard input if FILE is -")
+	flag.IntVar(&config.Concurrency, "concurrency", batch.DefaultConcurrency, "Maximum simultaneous downloads with -i")
+	flag.BoolVar(&config.FailFast, "fail-fast", false, "Stop starting new downloads after the first failure (default: continue on error)")
 	flag.BoolVar(&config.Mirror, "mirror", false, "Mirror entire website")
+	flag.StringVar(&config.Accept, "A", "", "Accept only these file types (comma-separated)")
+	flag.StringVar(&config.Accept, "accept", "", "Accept only these file types (comma-separated)")
 	flag.StringVar(&config.Reject, "R", "", "Reject file types (comma-separated)")
 	flag.StringVar(&config.Reject, "reject", "", "Reject file types (comma-separated)")
-	flag.StringVar(&config.Exclude, "X", "", "Exclude directories (comma-separated)")
-	flag.StringVar(&config.Exclude, "exclude", "", "Exclude directories (comma-separated)")
+	flag.StringVar(&config.Include, "I", "", "Only follow these directory trees, e.g. /a,/b (comma-separated)")
+	flag.StringVar(&config.Include, "include-directories", "", "Only follow these directory trees, e.g. /a,/b (comma-separated)")
+	flag.StringVar(&config.Exclude, "X", "", "Skip these directory trees, e.g. /a,/b (comma-separated)")
+	flag.StringVar(&config.Exclude, "exclude", "", "Skip these directory trees, e.g. /a,/b (comma-separated)")
 	flag.BoolVar(&config.ConvertLinks, "convert-links", false, "Convert links for offline viewing")
+	flag.BoolVar(&config.SpanHosts, "span-hosts", false, "Follow links to other hosts when mirroring")
+	flag.Float64Var(&config.Wait, "wait", 0, "Wait SECONDS between requests when mirroring")
+	flag.BoolVar(&config.RandomWait, "random-wait", false, "Randomize --wait between 0.5 and 1.5 times its value")
+	flag.BoolVar(&config.AdjustExtension, "E", false, "Save HTML and CSS pages with a .html or .css extension when mirroring")
+	flag.BoolVar(&config.AdjustExtension, "adjust-extension", false, "Save HTML and CSS pages with a .html or .css extension when mirroring")
+	flag.BoolVar(&config.PageRequisites, "p", false, "Download the images, stylesheets and scripts of every saved page, even past the depth limit")
+	flag.BoolVar(&config.PageRequisites, "page-requisites", false, "Download the images, stylesheets and scripts of every saved page, even past the depth limit")
+	flag.StringVar(&config.Level, "l", "", fmt.Sprintf("Follow links N levels deep when mirroring, 0 or inf for no limit (default %d)", mirror.DefaultMaxDepth))
+	flag.StringVar(&config.Level, "level", "", fmt.Sprintf("Follow links N levels deep when mirroring, 0 or inf for no limit (default %d)", mirror.DefaultMaxDepth))
+	flag.IntVar(&config.MaxFiles, "max-files", 0, fmt.Sprintf("Stop mirroring after saving N files (default %d)", mirror.DefaultMaxFiles))
+	flag.BoolVar(&config.Sitemap, "sitemap", false, "Also mirror the pages listed in the site's sitemap.xml")
+	flag.BoolVar(&config.NoParent, "no-parent", false, "Don't ascend above the start URL's directory when mirroring")
+	flag.StringVar(&config.Domains, "domains", "", "Hosts to follow with --span-hosts (comma-separated)")
+	flag.BoolVar(&config.Continue, "c", false, "Resume getting a partially-downloaded file")
+	flag.BoolVar(&config.Continue, "continue", false, "Resume getting a partially-downloaded file")
+	flag.BoolVar(&config.NoClobber, "nc", false, "Skip downloads that would overwrite existing files")
+	flag.BoolVar(&config.NoClobber, "no-clobber", false, "Skip downloads that would overwrite existing files")
+	flag.IntVar(&config.Backups, "backups", 0, "Keep up to N earlier versions of a replaced file as FILE.1 ... FILE.N")
+	flag.BoolVar(&config.Timestamping, "N", false, "Only download files newer than the local copy")
+	flag.BoolVar(&config.Timestamping, "timestamping", false, "Only download files newer than the local copy")
+	flag.BoolVar(&config.PreserveTimestamp, "preserve-timestamp", false, "Set each saved file's modification time from the server's Last-Modified header")
+	flag.BoolVar(&config.PreserveTimestamp, "timestamp-preserve", false, "Alias for --preserve-timestamp")
+	flag.IntVar(&config.Tries, "t", 3, "Number of tries on transient errors (0 for unlimited)")
+	flag.IntVar(&config.Tries, "tries", 3, "Number of tries on transient errors (0 for unlimited)")
+	flag.IntVar(&config.MaxRedirect, "max-redirect", httpclient.DefaultMaxRedirects, "Follow at most N redirects per request (0 to follow none)")
+	flag.Float64Var(&config.ConnectTimeout, "connect-timeout", 30, "Connection timeout in seconds")
+	flag.Float64Var(&config.ReadTimeout, "read-timeout", 0, "Idle read timeout in seconds (0 for no limit)")
+	flag.StringVar(&config.UserAgent, "U", "", "Identify as this User-Agent string")
+	flag.StringVar(&config.UserAgent, "user-agent", "", "Identify as this User-Agent string")
+	flag.Var(&config.Headers, "header", "Add a request header \"Name: Value\" (repeatable)")
+	flag.StringVar(&config.User, "user", "", "User name for HTTP or FTP authentication")
+	flag.StringVar(&config.Password, "password", "", "Password for HTTP or FTP authentication (prompted if omitted)")
+	flag.BoolVar(&config.Netrc, "netrc", false, "Log in with the credentials in ~/.netrc ($NETRC) when --user is not given")
+	flag.StringVar(&config.NetrcFile, "netrc-file", "", "Like --netrc, reading the credentials from FILE")
+	flag.BoolVar(&config.NoCheckCertificate, "no-check-certificate", false, "Don't verify the server's TLS certificate")
+	flag.StringVar(&config.Certificate, "certificate", "", "Present the PEM client certificate in FILE to servers that require one")
+	flag.StringVar(&config.PrivateKey, "private-key", "", "Read the private key for --certificate from FILE (default the certificate file)")
+	flag.StringVar(&config.CACertificate, "ca-certificate", "", "Also trust the PEM CA certificates in FILE")
+	flag.StringVar(&config.LoadCookies, "load-cookies", "", "Load cookies from a Netscape cookies.txt file")
+	flag.StringVar(&config.SaveCookies, "save-cookies", "", "Save cookies to a Netscape cookies.txt file after the run")
+	flag.BoolVar(&config.KeepSessionCookies, "keep-session-cookies", false, "Also save session cookies, which have no expiry time, with --save-cookies")
+	flag.StringVar(&config.Checksum, "checksum", "", "Verify the download against ALGORITHM:DIGEST (sha256, sha1 or md5)")
+	flag.IntVar(&config.Chunks, "chunks", 0, "Download a single file as N parallel range requests")
+	flag.StringVar(&config.PostData, "post-data", "", "Send a POST request with this form-encoded body")
+	flag.StringVar(&config.PostFile, "post-file", "", "Send a POST request with the contents of FILE as the body")
+	flag.BoolVar(&config.IgnoreLength, "ignore-length", false, "Ignore the Content-Length header sent by the server")
+	flag.BoolVar(&config.TrustServerNames, "trust-server-names", false, "Name downloads after the last URL of a redirect chain")
+	flag.BoolVar(&config.Spider, "spider", false, "Check that URLs exist without downloading them; with --mirror, report broken links")
+	flag.BoolVar(&config.ServerResponse, "S", false, "Print the headers sent by the server")
+	flag.BoolVar(&config.ServerResponse, "server-response", false, "Print the headers sent by the server")
+	flag.StringVar(&config.OutputFile, "o", "", fmt.Sprintf("Append messages to FILE (default %s with -B, otherwise standard output)", logging.LogFile))
+	flag.StringVar(&config.OutputFile, "output-file", "", fmt.Sprintf("Append messages to FILE (default %s with -B, otherwise standard output)", logging.LogFile))
+	flag.BoolVar(&config.Quiet, "q", false, "Quiet: print nothing but errors")
+	flag.BoolVar(&config.Quiet, "quiet", false, "Quiet: print nothing but errors")
+	flag.StringVar(&config.Progress, "progress", "", "Progress style: bar or dot (default bar on a terminal, dot otherwise)")
+	flag.Var(&config.SaveHeaders, "save-headers", "Save the response headers at the start of the file, or with =sidecar in FILE"+downloader.HeadersFileSuffix)
+	flag.StringVar(&config.Proxy, "proxy", "", "Proxy URL (http://, https:// or socks5://), overrides HTTP_PROXY/HTTPS_PROXY")
+	flag.StringVar(&config.BindAddress, "bind-address", "", "Make connections from this local IP address")
+	flag.BoolVar(&config.Inet4Only, "4", false, "Connect only over IPv4")
+	flag.BoolVar(&config.Inet4Only, "inet4-only", false, "Connect only over IPv4")
+	flag.BoolVar(&config.Inet6Only, "6", false, "Connect only over IPv6")
+	flag.BoolVar(&config.Inet6Only, "inet6-only", false, "Connect only over IPv6")
 
+	flag.Usage = usage
 	flag.Parse()
 
 	// Get URL from command line arguments
 	args := flag.Args()
-	
-	// Only set URL if we have args and no input file specified
+
+	// Only set URLs if we have args and no input file specified
 	if len(args) > 0 && config.InputFile == "" {
+		config.URLs = args
 		config.URL = args[0]
 	}
-	
+
 	// Check if we have either URL or input file
 	if config.URL == "" && config.InputFile == "" {
 		fmt.Fprintf(os.Stderr, "Error: URL or input file (-i) required\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] URL\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "   or: %s -i=FILE [OPTIONS]\n", os.Args[0])
-		flag.PrintDefaults()
-		os.Exit(1)
+		flag.Usage()
+		os.Exit(exitUsage)
 	}
 
 	// Validate flag combinations
 	if err := validateConfig(&config); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+
+	// A detached child receives a prompted password from its parent
+	if config.Daemon && config.Password == "" {
+		config.Password = os.Getenv(bg.PasswordEnv)
+	}
+
+	// Ask for the password on the terminal rather than the command line
+	if config.User != "" && config.Password == "" {
+		password, err := promptPassword(config.User)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitGeneric)
+		}
+		config.Password = password
+	}
+
+	if config.NoCheckCertificate {
+		fmt.Fprintf(os.Stderr, "Warning: TLS certificate verification is disabled\n")
+	}
+
+	// Hand background downloads to a detached copy of this process
+	if config.Background && !config.Daemon {
+		logFile := config.OutputFile
+		if logFile == "" {
+			logFile = logging.LogFile
+		}
+		pid, err := bg.Detach(logFile, config.Password)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitGeneric)
+		}
+		if !config.Quiet {
+			fmt.Printf("Continuing in background, pid %d.\n", pid)
+			fmt.Printf("Output will be written to \"%s\".\n", logFile)
+		}
+		return
 	}
 
 	// Initialize logging
-	logger := logging.NewLogger(config.Background)
+	logger := logging.NewLogger(config.Background, config.OutputFile)
+	if config.Progress != "" {
+		logger.SetProgressStyle(config.Progress)
+	}
+	// The background log keeps its full output
+	if config.Quiet && !config.Background {
+		logger.SetQuiet()
+	}
+
+	// Cancel in-flight downloads on Ctrl-C or SIGTERM
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleInterrupts(cancel)
 
 	// Execute based on configuration
-	if err := executeDownload(&config, logger); err != nil {
+	err := executeDownload(ctx, &config, logger)
+	logger.Close()
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "Interrupted\n")
+		os.Exit(exitSignal)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
+// usage prints the command line help, including the exit statuses
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] URL...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "   or: %s -i=FILE [OPTIONS]\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, `
+Exit status:
+  0  success
+  1  generic error
+  2  invalid command line
+  3  file I/O error
+  4  network failure
+  5  TLS certificate verification failure
+  8  server error response (4xx or 5xx)
+When several downloads fail, the lowest of codes 2-8 is used.
+`)
+}
+
+// exitCode maps a download error to an exit status. When several downloads
+// failed, the lowest specific status wins, as in GNU wget.
+func exitCode(err error) int {
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		code := exitGeneric
+		for _, e := range joined.Unwrap() {
+			if c := exitCode(e); c != exitGeneric && (code == exitGeneric || c < code) {
+				code = c
+			}
+		}
+		return code
+	}
+
+	// Certificate failures also arrive wrapped in a NetworkError
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var statusErr *downloader.StatusError
+	var networkErr *downloader.NetworkError
+	var writeErr *downloader.WriteError
+	switch {
+	case errors.As(err, &verifyErr), errors.As(err, &unknownAuthority),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidCert):
+		return exitSSL
+	case errors.As(err, &statusErr):
+		return exitServer
+	case errors.As(err, &networkErr):
+		return exitNetwork
+	case errors.As(err, &writeErr):
+		return exitIO
+	}
+	return exitGeneric
+}
+
+// handleInterrupts calls cancel on the first SIGINT or SIGTERM so downloads can
+// clean up, and exits immediately on the second
+func handleInterrupts(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Fprintf(os.Stderr, "\nInterrupted, cleaning up (press Ctrl-C again to quit immediately)\n")
+		cancel()
+		<-signals
+		os.Exit(exitSignal)
+	}()
+}
+
 func validateConfig(config *Config) error {
 	// Mirror-specific validations
-	if (config.Reject != "" || config.Exclude != "" || config.ConvertLinks) && !config.Mirror {
-		return fmt.Errorf("--reject, --exclude, and --convert-links can only be used with --mirror")
+	if (config.Accept != "" || config.Reject != "" || config.Include != "" || config.Exclude != "" || config.ConvertLinks || config.SpanHosts) && !config.Mirror {
+		return fmt.Errorf("--accept, --reject, --include-directories, --exclude, --convert-links, and --span-hosts can only be used with --mirror")
+	}
+	if config.NoParent && !config.Mirror {
+		return fmt.Errorf("--no-parent can only be used with --mirror")
+	}
+	if config.Sitemap && !config.Mirror {
+		return fmt.Errorf("--sitemap can only be used with --mirror")
+	}
+	if config.AdjustExtension && !config.Mirror {
+		return fmt.Errorf("--adjust-extension can only be used with --mirror")
+	}
+	if config.PageRequisites && !config.Mirror {
+		return fmt.Errorf("--page-requisites can only be used with --mirror")
+	}
+	if (config.Level != "" || config.MaxFiles != 0) && !config.Mirror {
+		return fmt.Errorf("--level and --max-files can only be used with --mirror")
+	}
+	if _, err := parseLevel(config.Level); err != nil {
+		return err
+	}
+	if _, err := parseQuota(config.Quota); err != nil {
+		return err
+	}
+	if config.MaxFiles < 0 {
+		return fmt.Errorf("--max-files must not be negative")
+	}
+	if (config.Wait != 0 || config.RandomWait) && !config.Mirror {
+		return fmt.Errorf("--wait and --random-wait can only be used with --mirror")
+	}
+	if config.Wait < 0 {
+		return fmt.Errorf("--wait must not be negative")
+	}
+	if config.MaxRedirect < 0 {
+		return fmt.Errorf("--max-redirect must not be negative")
+	}
+	if config.Inet4Only && config.Inet6Only {
+		return fmt.Errorf("--inet4-only and --inet6-only cannot be used together")
+	}
+	if config.BindAddress != "" {
+		if _, err := httpclient.ParseBindAddress(config.BindAddress); err != nil {
+			return fmt.Errorf("invalid --bind-address: %v", err)
+		}
+	}
+	if config.PrivateKey != "" && config.Certificate == "" {
+		return fmt.Errorf("--private-key requires --certificate")
+	}
+	if config.Certificate != "" {
+		if _, err := httpclient.LoadClientCertificate(config.Certificate, config.PrivateKey); err != nil {
+			return err
+		}
+	}
+	if config.CACertificate != "" {
+		if _, err := httpclient.LoadCACertificates(config.CACertificate); err != nil {
+			return err
+		}
+	}
+	if config.Domains != "" && !config.SpanHosts {
+		return fmt.Errorf("--domains requires --span-hosts")
+	}
+
+	// Resuming appends to existing files, which no-clobber forbids
+	if config.Continue && config.NoClobber {
+		return fmt.Errorf("--continue and --no-clobber cannot be used together")
+	}
+
+	// Backups are made of files being replaced, which no-clobber forbids
+	if config.Backups < 0 {
+		return fmt.Errorf("--backups must not be negative")
+	}
+	if config.Backups > 0 && config.NoClobber {
+		return fmt.Errorf("--backups and --no-clobber cannot be used together")
+	}
+	if config.Backups > 0 && config.Mirror {
+		return fmt.Errorf("--backups cannot be used with --mirror")
+	}
+
+	// Timestamping decides on its own whether to replace existing files
+	if config.Timestamping && config.NoClobber {
+		return fmt.Errorf("--timestamping and --no-clobber cannot be used together")
+	}
+
+	// Retry count cannot be negative
+	if config.Tries < 0 {
+		return fmt.Errorf("--tries must not be negative")
+	}
+
+	// Timeouts are durations in seconds
+	if config.ConnectTimeout < 0 || config.ReadTimeout < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+
+	// Every header must be of the form "Name: Value"
+	for _, header := range config.Headers {
+		if _, _, err := parseHeader(header); err != nil {
+			return err
+		}
+	}
+
+	// Catch unsupported schemes before any request is made. URLs without a
+	// scheme get one assumed later.
+	for _, rawURL := range config.URLs {
+		if httpclient.MissingScheme(rawURL) {
+			continue
+		}
+		parsedURL, err := url.Parse(rawURL)
+		if err != nil {
+			return fmt.Errorf("invalid URL %s: %v", logging.RedactURL(rawURL), err)
+		}
+		if err := downloader.CheckScheme(parsedURL); err != nil {
+			return err
+		}
+		if config.Mirror && parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+			return fmt.Errorf("--mirror only supports http and https URLs")
+		}
+	}
+
+	switch config.Progress {
+	case "", logging.ProgressBar, logging.ProgressDot:
+	default:
+		return fmt.Errorf("--progress must be %s or %s", logging.ProgressBar, logging.ProgressDot)
+	}
+
+	// Several downloads can't share one output name
+	multipleURLs := config.InputFile != "" || len(config.URLs) > 1
+	if config.OutputName != "" && multipleURLs {
+		return fmt.Errorf("-O cannot be used with more than one URL")
+	}
+
+	// Batch downloads don't show per-file output, headers included
+	if config.ServerResponse && multipleURLs && !config.Mirror {
+		return fmt.Errorf("--server-response can only be used with a single URL or --mirror")
+	}
+
+	if config.FailFast && !multipleURLs {
+		return fmt.Errorf("--fail-fast can only be used when downloading several URLs")
+	}
+
+	// Headers are only saved with files that are written
+	if config.SaveHeaders != "" && (config.Mirror || config.Spider) {
+		return fmt.Errorf("--save-headers cannot be used with --mirror or --spider")
+	}
+	if config.SaveHeaders == downloader.SaveHeadersInline && config.Continue {
+		return fmt.Errorf("--continue cannot be used with --save-headers=inline")
+	}
+
+	// A checksum only makes sense for a single file
+	if config.Checksum != "" && config.Spider {
+		return fmt.Errorf("--checksum cannot be used with --spider")
+	}
+	if config.Checksum != "" {
+		if multipleURLs || config.Mirror {
+			return fmt.Errorf("--checksum can only be used when downloading a single URL")
+		}
+		if _, err := downloader.ParseChecksum(config.Checksum); err != nil {
+			return err
+		}
+	}
+
+	// Parallel chunks only apply to a single file
+	if config.Chunks < 0 {
+		return fmt.Errorf("--chunks must not be negative")
+	}
+	if config.Chunks > 1 && (multipleURLs || config.Mirror) {
+		return fmt.Errorf("--chunks can only be used when downloading a single URL")
+	}
+
+	// A POST body applies to a single request
+	if config.PostData != "" && config.PostFile != "" {
+		return fmt.Errorf("--post-data and --post-file cannot be used together")
+	}
+	if (config.PostData != "" || config.PostFile != "") && (multipleURLs || config.Mirror) {
+		return fmt.Errorf("--post-data and --post-file can only be used when downloading a single URL")
+	}
+
+	// Concurrency needs at least one worker
+	if config.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
 	}
 
 	// Don't allow both input file and URL
@@ -87,46 +576,256 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("cannot specify both input file (-i) and URL")
 	}
 
+	// The detached background process has no standard input to read from
+	if config.InputFile == batch.StdinFilename && config.Background {
+		return fmt.Errorf("-i - cannot be used with -B")
+	}
+
 	return nil
 }
 
-func executeDownload(config *Config, logger *logging.Logger) error {
-	// Background download
-	if config.Background {
-		return bg.DownloadInBackground(config.URL, &bg.Options{
-			OutputName: config.OutputName,
-			OutputPath: config.OutputPath,
-			RateLimit:  config.RateLimit,
-		}, logger)
+func executeDownload(ctx context.Context, config *Config, logger *logging.Logger) error {
+	connectTimeout := secondsToDuration(config.ConnectTimeout)
+	readTimeout := secondsToDuration(config.ReadTimeout)
+	headers := parseHeaders(config.Headers)
+
+	quota, _ := parseQuota(config.Quota)
+
+	// Restrict connections to one address family
+	network := ""
+	switch {
+	case config.Inet4Only:
+		network = "tcp4"
+	case config.Inet6Only:
+		network = "tcp6"
 	}
 
-	// Batch download from file
-	if config.InputFile != "" {
-		return batch.DownloadFromFile(config.InputFile, &batch.Options{
-			OutputPath: config.OutputPath,
-			RateLimit:  config.RateLimit,
-		}, logger)
+	// --max-redirect=0 follows no redirects, while the clients read 0 as the default
+	maxRedirects := config.MaxRedirect
+	if maxRedirects == 0 {
+		maxRedirects = httpclient.NoRedirects
+	}
+
+	// Read the POST body from a file when requested
+	postData := config.PostData
+	if config.PostFile != "" {
+		data, err := os.ReadFile(config.PostFile)
+		if err != nil {
+			return fmt.Errorf("failed to read post file: %v", err)
+		}
+		postData = string(data)
+	}
+
+	// Set up a cookie jar shared by every request in this run
+	var jar http.CookieJar
+	if config.LoadCookies != "" || config.SaveCookies != "" {
+		cookieJar, err := httpclient.NewCookieJar()
+		if err != nil {
+			return fmt.Errorf("failed to create cookie jar: %v", err)
+		}
+		if config.LoadCookies != "" {
+			if err := cookieJar.Load(config.LoadCookies); err != nil {
+				return fmt.Errorf("failed to load cookies: %v", err)
+			}
+		}
+		if config.SaveCookies != "" {
+			defer func() {
+				if err := cookieJar.Save(config.SaveCookies, config.KeepSessionCookies); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to save cookies: %v\n", err)
+				}
+			}()
+		}
+		jar = cookieJar
+	}
+
+	// Hosts without explicit credentials log in with their netrc entry
+	var netrc *httpclient.Netrc
+	if config.Netrc || config.NetrcFile != "" {
+		path := config.NetrcFile
+		if path == "" {
+			path = httpclient.DefaultNetrcPath()
+		}
+		loaded, err := httpclient.LoadNetrc(path)
+		if err != nil {
+			return fmt.Errorf("failed to load netrc: %v", err)
+		}
+		netrc = loaded
+	}
+
+	// Batch download from a file or several command-line URLs
+	if config.InputFile != "" || (len(config.URLs) > 1 && !config.Mirror) {
+		batchOptions := &batch.Options{
+			OutputPath:         config.OutputPath,
+			RateLimit:          config.RateLimit,
+			Quota:              quota,
+			Continue:           config.Continue,
+			Tries:              config.Tries,
+			ConnectTimeout:     connectTimeout,
+			ReadTimeout:        readTimeout,
+			UserAgent:          config.UserAgent,
+			Headers:            headers,
+			User:               config.User,
+			Password:           config.Password,
+			Netrc:              netrc,
+			Proxy:              config.Proxy,
+			BindAddress:        config.BindAddress,
+			Network:            network,
+			NoCheckCertificate: config.NoCheckCertificate,
+			Certificate:        config.Certificate,
+			PrivateKey:         config.PrivateKey,
+			CACertificate:      config.CACertificate,
+			CookieJar:          jar,
+			MaxRedirects:       maxRedirects,
+			NoClobber:          config.NoClobber,
+			Backups:            config.Backups,
+			Timestamping:       config.Timestamping,
+			PreserveTimestamp:  config.PreserveTimestamp,
+			Concurrency:        config.Concurrency,
+			FailFast:           config.FailFast,
+			IgnoreLength:       config.IgnoreLength,
+			TrustServerNames:   config.TrustServerNames,
+			Spider:             config.Spider,
+			SaveHeaders:        string(config.SaveHeaders),
+		}
+		if config.InputFile != "" {
+			return batch.DownloadFromFile(ctx, config.InputFile, batchOptions, logger)
+		}
+		return batch.DownloadURLs(ctx, config.URLs, batchOptions, logger)
 	}
 
 	// Website mirroring
 	if config.Mirror {
+		acceptTypes := parseCommaSeparated(config.Accept)
 		rejectTypes := parseCommaSeparated(config.Reject)
 		excludeDirs := parseCommaSeparated(config.Exclude)
+		maxDepth, _ := parseLevel(config.Level)
 
-		return mirror.MirrorWebsite(config.URL, &mirror.Options{
-			RejectTypes:  rejectTypes,
-			ExcludeDirs:  excludeDirs,
-			ConvertLinks: config.ConvertLinks,
-			OutputPath:   config.OutputPath,
-			RateLimit:    config.RateLimit,
+		mirrorOptions := &mirror.Options{
+			AcceptTypes:        acceptTypes,
+			RejectTypes:        rejectTypes,
+			IncludeDirs:        parseCommaSeparated(config.Include),
+			ExcludeDirs:        excludeDirs,
+			ConvertLinks:       config.ConvertLinks,
+			SpanHosts:          config.SpanHosts,
+			Domains:            parseCommaSeparated(config.Domains),
+			Wait:               secondsToDuration(config.Wait),
+			RandomWait:         config.RandomWait,
+			OutputPath:         config.OutputPath,
+			RateLimit:          config.RateLimit,
+			Quota:              quota,
+			ConnectTimeout:     connectTimeout,
+			ReadTimeout:        readTimeout,
+			UserAgent:          config.UserAgent,
+			Headers:            headers,
+			User:               config.User,
+			Password:           config.Password,
+			Netrc:              netrc,
+			Proxy:              config.Proxy,
+			BindAddress:        config.BindAddress,
+			Network:            network,
+			NoCheckCertificate: config.NoCheckCertificate,
+			Certificate:        config.Certificate,
+			PrivateKey:         config.PrivateKey,
+			CACertificate:      config.CACertificate,
+			CookieJar:          jar,
+			MaxRedirects:       maxRedirects,
+			NoClobber:          config.NoClobber,
+			Timestamping:       config.Timestamping,
+			PreserveTimestamp:  config.PreserveTimestamp,
+			NoParent:           config.NoParent,
+			AdjustExtension:    config.AdjustExtension,
+			PageRequisites:     config.PageRequisites,
+			MaxDepth:           maxDepth,
+			MaxFiles:           config.MaxFiles,
+			Spider:             config.Spider,
+			ServerResponse:     config.ServerResponse,
+			Sitemap:            config.Sitemap,
+		}
+		for _, url := range config.URLs {
+			if err := mirror.MirrorWebsite(ctx, url, mirrorOptions, logger); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Background download of a single file. Batch and mirror runs above
+	// already happen inside the detached process.
+	if config.Background {
+		return bg.DownloadInBackground(ctx, config.URL, &bg.Options{
+			OutputName:         config.OutputName,
+			OutputPath:         config.OutputPath,
+			RateLimit:          config.RateLimit,
+			Quota:              quota,
+			Continue:           config.Continue,
+			Tries:              config.Tries,
+			ConnectTimeout:     connectTimeout,
+			ReadTimeout:        readTimeout,
+			UserAgent:          config.UserAgent,
+			Headers:            headers,
+			User:               config.User,
+			Password:           config.Password,
+			Netrc:              netrc,
+			Proxy:              config.Proxy,
+			BindAddress:        config.BindAddress,
+			Network:            network,
+			NoCheckCertificate: config.NoCheckCertificate,
+			Certificate:        config.Certificate,
+			PrivateKey:         config.PrivateKey,
+			CACertificate:      config.CACertificate,
+			CookieJar:          jar,
+			MaxRedirects:       maxRedirects,
+			NoClobber:          config.NoClobber,
+			Backups:            config.Backups,
+			Timestamping:       config.Timestamping,
+			PreserveTimestamp:  config.PreserveTimestamp,
+			Checksum:           config.Checksum,
+			Chunks:             config.Chunks,
+			PostData:           postData,
+			IgnoreLength:       config.IgnoreLength,
+			TrustServerNames:   config.TrustServerNames,
+			Spider:             config.Spider,
+			ServerResponse:     config.ServerResponse,
+			SaveHeaders:        string(config.SaveHeaders),
 		}, logger)
 	}
 
 	// Single file download
-	return downloader.DownloadFile(config.URL, &downloader.Options{
-		OutputName: config.OutputName,
-		OutputPath: config.OutputPath,
-		RateLimit:  config.RateLimit,
+	return downloader.DownloadFile(ctx, config.URL, &downloader.Options{
+		OutputName:         config.OutputName,
+		OutputPath:         config.OutputPath,
+		RateLimit:          config.RateLimit,
+		Quota:              downloader.NewQuota(quota),
+		Continue:           config.Continue,
+		Tries:              config.Tries,
+		ConnectTimeout:     connectTimeout,
+		ReadTimeout:        readTimeout,
+		UserAgent:          config.UserAgent,
+		Headers:            headers,
+		User:               config.User,
+		Password:           config.Password,
+		Netrc:              netrc,
+		Proxy:              config.Proxy,
+		BindAddress:        config.BindAddress,
+		Network:            network,
+		NoCheckCertificate: config.NoCheckCertificate,
+		Certificate:        config.Certificate,
+		PrivateKey:         config.PrivateKey,
+		CACertificate:      config.CACertificate,
+		CookieJar:          jar,
+		MaxRedirects:       maxRedirects,
+		NoClobber:          config.NoClobber,
+		Backups:            config.Backups,
+		Timestamping:       config.Timestamping,
+		PreserveTimestamp:  config.PreserveTimestamp,
+		Checksum:           config.Checksum,
+		Chunks:             config.Chunks,
+		PostData:           postData,
+		IgnoreLength:       config.IgnoreLength,
+		TrustServerNames:   config.TrustServerNames,
+		Spider:             config.Spider,
+		ServerResponse:     config.ServerResponse,
+		SaveHeaders:        string(config.SaveHeaders),
 	}, logger)
 }
 
@@ -144,3 +843,79 @@ func parseCommaSeparated(input string) []string {
 	}
 	return result
 }
+
+// secondsToDuration converts a command-line value in seconds to a time.Duration
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// parseLevel converts a --level value to a mirror depth. An empty value keeps
+// the default, and "0" or "inf" remove the limit.
+func parseLevel(level string) (int, error) {
+	switch level {
+	case "":
+		return 0, nil
+	case "0", "inf":
+		return mirror.Unlimited, nil
+	}
+	depth, err := strconv.Atoi(level)
+	if err != nil || depth < 0 {
+		return 0, fmt.Errorf("invalid --level %q: expected a number of levels or inf", level)
+	}
+	return depth, nil
+}
+
+// parseQuota converts a --quota value to bytes. An empty value, "0" or "inf"
+// mean no quota.
+func parseQuota(quota string) (int64, error) {
+	switch quota {
+	case "", "0", "inf":
+		return 0, nil
+	}
+	size, err := ratelimit.ParseSize(quota)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --quota: %v", err)
+	}
+	return size, nil
+}
+
+// parseHeader splits a "Name: Value" header into its name and value
+func parseHeader(header string) (string, string, error) {
+	name, value, found := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+	if !found || name == "" {
+		return "", "", fmt.Errorf("invalid header %q: expected \"Name: Value\"", header)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// parseHeaders converts validated --header values into an http.Header
+func parseHeaders(headers []string) http.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+	result := make(http.Header)
+	for _, header := range headers {
+		name, value, err := parseHeader(header)
+		if err == nil {
+			result.Add(name, value)
+		}
+	}
+	return result
+}
+
+// promptPassword reads a password from the terminal without echoing it
+func promptPassword(user string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("--password is required when stdin is not a terminal")
+	}
+
+	fmt.Fprintf(os.Stderr, "Password for user %q: ", user)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %v", err)
+	}
+	return string(password), nil
+}